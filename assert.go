@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mcjr/chail/engine"
+)
+
+// exitAssertionFailed is the exit code if at least one assertion is violated
+const exitAssertionFailed = 2
+
+var assertionPattern = regexp.MustCompile(`^\s*(avg|p\d{1,3}(?:\.\d+)?|errors|rps)\s*(<=|>=|<|>)\s*(\S+)\s*$`)
+
+// Assertion is a threshold for a metric of a probe, e.g. p95<300ms
+type Assertion struct {
+	Metric   string
+	Operator string
+	Value    float64
+}
+
+func (a Assertion) String() string {
+	return a.Metric + a.Operator + a.format(a.Value)
+}
+
+// measure returns the value of the asserted metric for the probe, NaN for the total time without successful requests,
+// so that every threshold of it is violated
+func (a Assertion) measure(p *engine.Probe) float64 {
+	switch a.Metric {
+	case "errors":
+		return p.ErrRate
+	case "rps":
+		return p.RPS()
+	}
+	if len(p.TimeTotalNanos) == 0 {
+		return math.NaN()
+	}
+	if a.Metric == "avg" {
+		return p.AvgTimeTotalNano
+	}
+	q, _ := strconv.ParseFloat(strings.TrimPrefix(a.Metric, "p"), 64)
+	return p.Percentile(q)
+}
+
+// holds is true if and only if the measured value satisfies the threshold
+func (a Assertion) holds(value float64) bool {
+	switch a.Operator {
+	case "<":
+		return value < a.Value
+	case "<=":
+		return value <= a.Value
+	case ">":
+		return value > a.Value
+	case ">=":
+		return value >= a.Value
+	}
+	return false
+}
+
+func (a Assertion) format(value float64) string {
+	switch a.Metric {
+	case "errors":
+		return strconv.FormatFloat(value*100, 'f', -1, 64) + "%"
+	case "rps":
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	if math.IsNaN(value) {
+		return "no successful requests"
+	}
+	return time.Duration(value).String()
+}
+
+// Assertions from arguments
+type Assertions []Assertion
+
+func (a *Assertions) String() string {
+	terms := make([]string, len(*a))
+	for i, assertion := range *a {
+		terms[i] = assertion.String()
+	}
+	return strings.Join(terms, ",")
+}
+
+// Set Assertion from argument
+func (a *Assertions) Set(s string) error {
+	match := assertionPattern.FindStringSubmatch(s)
+	if match == nil {
+		return fmt.Errorf("invalid assertion %q", s)
+	}
+	assertion := Assertion{Metric: match[1], Operator: match[2]}
+	switch assertion.Metric {
+	case "errors":
+		value, err := strconv.ParseFloat(strings.TrimSuffix(match[3], "%"), 64)
+		if err != nil {
+			return fmt.Errorf("invalid error rate in assertion %q", s)
+		}
+		if strings.HasSuffix(match[3], "%") {
+			value /= 100
+		}
+		assertion.Value = value
+	case "rps":
+		value, err := strconv.ParseFloat(match[3], 64)
+		if err != nil {
+			return fmt.Errorf("invalid rate in assertion %q", s)
+		}
+		assertion.Value = value
+	default:
+		if q, _ := strconv.ParseFloat(strings.TrimPrefix(assertion.Metric, "p"), 64); assertion.Metric != "avg" && (q <= 0 || q > 100) {
+			return fmt.Errorf("invalid percentile in assertion %q", s)
+		}
+		value, err := time.ParseDuration(match[3])
+		if err != nil {
+			return fmt.Errorf("invalid duration in assertion %q", s)
+		}
+		assertion.Value = float64(value)
+	}
+	*a = append(*a, assertion)
+	return nil
+}
+
+// Type description of argument
+func (a *Assertions) Type() string {
+	return "threshold"
+}
+
+type violation struct {
+	assertion Assertion
+	clients   int // 0 for the whole run
+	value     float64
+}
+
+func (v violation) String() string {
+	if v.clients == 0 {
+		return fmt.Sprintf("%s violated by run: %s", v.assertion, v.assertion.format(v.value))
+	}
+	return fmt.Sprintf("%s violated by step %d: %s", v.assertion, v.clients, v.assertion.format(v.value))
+}
+
+// checkAssertions against the whole run or against every single step
+func checkAssertions(assertions Assertions, probes []engine.Probe, eachStep bool) []violation {
+	var violations []violation
+	if eachStep {
+		for i := range probes {
+			for _, assertion := range assertions {
+				if value := assertion.measure(&probes[i]); !assertion.holds(value) {
+					violations = append(violations, violation{assertion, probes[i].Clients, value})
+				}
+			}
+		}
+		return violations
+	}
+	run := engine.Merge(probes)
+	for _, assertion := range assertions {
+		if value := assertion.measure(&run); !assertion.holds(value) {
+			violations = append(violations, violation{assertion, 0, value})
+		}
+	}
+	return violations
+}
+
+func printAssertions(assertions Assertions, probes []engine.Probe, violations []violation) {
+	run := engine.Merge(probes)
+	color.Cyan("Assertions:")
+	for _, assertion := range assertions {
+		fmt.Fprintf(color.Output, "  %s: %s", assertion, assertion.format(assertion.measure(&run)))
+		failed := false
+		for _, v := range violations {
+			failed = failed || v.assertion == assertion
+		}
+		if failed {
+			color.Red(" FAILED")
+		} else {
+			color.Green(" OK")
+		}
+	}
+	for _, v := range violations {
+		color.Red(v.String())
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mcjr/chail/engine"
+)
+
+func TestAssertionsSet(t *testing.T) {
+	assertAssertion(t, "p95<300ms", Assertion{"p95", "<", float64(300 * time.Millisecond)})
+	assertAssertion(t, "p99.9 <= 1s", Assertion{"p99.9", "<=", float64(time.Second)})
+	assertAssertion(t, "p100<2s", Assertion{"p100", "<", float64(2 * time.Second)})
+	assertAssertion(t, "avg<20ms", Assertion{"avg", "<", float64(20 * time.Millisecond)})
+	assertAssertion(t, "errors<1%", Assertion{"errors", "<", 0.01})
+	assertAssertion(t, "errors<=0.05", Assertion{"errors", "<=", 0.05})
+	assertAssertion(t, "rps>200", Assertion{"rps", ">", 200})
+}
+
+func TestAssertionsSetWithError(t *testing.T) {
+	for _, line := range []string{"", "p95", "p95=300ms", "p0<1s", "p100.5<1s", "p101<1s", "p95<300", "errors<x%", "rps>fast", "latency<1s"} {
+		var assertions Assertions
+		if err := assertions.Set(line); err == nil {
+			t.Errorf("Assertions.Set(%q) must return an error!", line)
+		}
+	}
+}
+
+func assertAssertion(t *testing.T, line string, expected Assertion) {
+	var assertions Assertions
+	err := assertions.Set(line)
+	if err != nil {
+		t.Errorf("Assertions.Set(%q) has error: %v", line, err)
+		return
+	}
+	if len(assertions) != 1 || assertions[0] != expected {
+		t.Errorf("Assertions.Set(%q) has invalid value: %v, expected %v", line, assertions, expected)
+	}
+}
+
+func TestCheckAssertions(t *testing.T) {
+	probes := []engine.Probe{
+		{Clients: 1, Requests: 4, Duration: time.Second, ErrRate: 0.0, AvgTimeTotalNano: 15e6, TimeTotalNanos: []int64{10e6, 10e6, 20e6, 20e6}},
+		{Clients: 2, Requests: 4, Duration: time.Second, ErrRate: 0.5, AvgTimeTotalNano: 300e6, TimeTotalNanos: []int64{100e6, 500e6}},
+	}
+	var assertions Assertions
+	assertions.Set("p95<300ms")
+	assertions.Set("errors<30%")
+	assertions.Set("rps>=4")
+
+	if violations := checkAssertions(assertions, probes, false); len(violations) != 1 || violations[0].assertion.Metric != "p95" {
+		t.Errorf("checkAssertions for run expects violation of p95, but got %v", violations)
+	}
+	if violations := checkAssertions(assertions, probes, true); len(violations) != 2 || violations[0].clients != 2 || violations[1].assertion.Metric != "errors" {
+		t.Errorf("checkAssertions for each step expects violations of p95 and errors in step 2, but got %v", violations)
+	}
+}
+
+func TestCheckAssertionsWithoutSuccesses(t *testing.T) {
+	probes := []engine.Probe{
+		{Clients: 1, Requests: 4, Duration: time.Second, ErrRate: 1, ResponseCodeCount: map[int]int{500: 4}},
+		{Clients: 2, Requests: 4, Duration: time.Second, ErrRate: 0.5, AvgTimeTotalNano: 10e6, TimeTotalNanos: []int64{10e6, 10e6}},
+	}
+	var assertions Assertions
+	assertions.Set("p95<300ms")
+	assertions.Set("avg<20ms")
+	assertions.Set("p50>1ms")
+
+	if violations := checkAssertions(assertions, probes[:1], false); len(violations) != 3 {
+		t.Errorf("checkAssertions for run without successful requests expects 3 violations, but got %v", violations)
+	}
+	violations := checkAssertions(assertions, probes, true)
+	if len(violations) != 3 || violations[0].clients != 1 || violations[2].clients != 1 {
+		t.Errorf("checkAssertions for each step expects 3 violations in step 1, but got %v", violations)
+	} else if s := violations[1].String(); s != "avg<20ms violated by step 1: no successful requests" {
+		t.Errorf("Violation without successful requests has invalid text: %q", s)
+	}
+}
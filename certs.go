@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// loadCertificates of the clients, every certificate file with the key file of the same position or its own key
+func loadCertificates(certFiles, keyFiles []string, certType, password string) ([]tls.Certificate, error) {
+	if len(keyFiles) > 0 && len(keyFiles) != len(certFiles) {
+		return nil, fmt.Errorf("%d certificates, but %d keys", len(certFiles), len(keyFiles))
+	}
+	certificates := make([]tls.Certificate, 0, len(certFiles))
+	for i, certFile := range certFiles {
+		var certificate tls.Certificate
+		var err error
+		switch strings.ToUpper(certType) {
+		case "PEM":
+			keyFile := certFile
+			if len(keyFiles) > 0 {
+				keyFile = keyFiles[i]
+			}
+			certificate, err = loadPEMCertificate(certFile, keyFile, password)
+		case "P12":
+			if len(keyFiles) > 0 {
+				return nil, fmt.Errorf("PKCS#12 file %s contains the key, --key is not allowed", certFile)
+			}
+			certificate, err = loadP12Certificate(certFile, password)
+		default:
+			return nil, fmt.Errorf("invalid certificate type %q, expected PEM or P12", certType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate %s failed: %v", certFile, err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	return certificates, nil
+}
+
+func loadPEMCertificate(certFile, keyFile, password string) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err = decryptPEM(keyPEM, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%s: %v", keyFile, err)
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// decryptPEM decrypts the encrypted PKCS#8 blocks of a PEM file, legacy encrypted PEM blocks are not supported
+func decryptPEM(content []byte, password string) ([]byte, error) {
+	var decrypted []byte
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		if block.Headers["Proc-Type"] == "4,ENCRYPTED" {
+			return nil, fmt.Errorf("legacy encrypted PEM keys are not supported, convert the key with 'openssl pkcs8 -topk8' or use PKCS#12")
+		}
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			if password == "" {
+				return nil, fmt.Errorf("encrypted private key requires a password")
+			}
+			key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+			if err != nil {
+				return nil, err
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				return nil, err
+			}
+			block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		}
+		decrypted = append(decrypted, pem.EncodeToMemory(block)...)
+	}
+	return decrypted, nil
+}
+
+func loadP12Certificate(file, password string) (tls.Certificate, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, leaf, chain, err := pkcs12.DecodeChain(content, password)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certificate := tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: key, Leaf: leaf}
+	for _, c := range chain {
+		certificate.Certificate = append(certificate.Certificate, c.Raw)
+	}
+	return certificate, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func TestLoadCertificatesPEM(t *testing.T) {
+	dir := t.TempDir()
+	a, b := loadTestCertificate(t, "client-a"), loadTestCertificate(t, "client-b")
+	certA := writeTestFile(t, dir, "a.crt", a.certPEM())
+	keyA := writeTestFile(t, dir, "a.key", a.keyPEM(t))
+	combinedB := writeTestFile(t, dir, "b.pem", append(b.certPEM(), b.keyPEM(t)...))
+
+	certificates, err := loadCertificates([]string{certA}, []string{keyA}, "PEM", "")
+	assertCertificates(t, certificates, err, "client-a")
+
+	if _, err = loadCertificates([]string{certA, combinedB}, nil, "pem", ""); err == nil {
+		t.Errorf("Certificate without key must return an error!")
+	}
+	certificates, err = loadCertificates([]string{combinedB}, nil, "PEM", "")
+	assertCertificates(t, certificates, err, "client-b")
+
+	if _, err = loadCertificates([]string{certA, combinedB}, []string{keyA}, "PEM", ""); err == nil {
+		t.Errorf("Certificates and keys of different count must return an error!")
+	}
+}
+
+func TestLoadCertificatesLegacyEncryptedPEM(t *testing.T) {
+	dir := t.TempDir()
+	certA := writeTestFile(t, dir, "a.crt", loadTestCertificate(t, "client-a").certPEM())
+	keyA := filepath.Join("testdata", "client-a-legacy.key")
+
+	// encrypted with openssl ec -aes256
+	if _, err := loadCertificates([]string{certA}, []string{keyA}, "PEM", "secret"); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Legacy encrypted PEM key must return a clear error, but was %v", err)
+	}
+}
+
+func TestLoadCertificatesPKCS8(t *testing.T) {
+	dir := t.TempDir()
+	certA := writeTestFile(t, dir, "a.crt", loadTestCertificate(t, "client-a").certPEM())
+	keyA := filepath.Join("testdata", "client-a-pkcs8.key")
+
+	// encrypted with the defaults of openssl pkcs8 -topk8: PBES2, PBKDF2 with HMAC-SHA256 and AES-256-CBC
+	certificates, err := loadCertificates([]string{certA}, []string{keyA}, "PEM", "secret")
+	assertCertificates(t, certificates, err, "client-a")
+
+	if _, err = loadCertificates([]string{certA}, []string{keyA}, "PEM", "wrong"); err == nil {
+		t.Errorf("Encrypted PKCS#8 key with wrong password must return an error!")
+	}
+	if _, err = loadCertificates([]string{certA}, []string{keyA}, "PEM", ""); err == nil || !strings.Contains(err.Error(), "requires a password") {
+		t.Errorf("Encrypted PKCS#8 key without password must return a clear error, but was %v", err)
+	}
+	keyPBES1 := filepath.Join("testdata", "client-a-pbes1.key")
+	if _, err = loadCertificates([]string{certA}, []string{keyPBES1}, "PEM", "secret"); err == nil || !strings.Contains(err.Error(), "only PBES2") {
+		t.Errorf("PKCS#8 key with PBES1 must return a clear error, but was %v", err)
+	}
+}
+
+func TestLoadCertificatesP12(t *testing.T) {
+	dir := t.TempDir()
+	a := loadTestCertificate(t, "client-a")
+	content, err := pkcs12.Modern.Encode(a.key, a.cert, nil, "secret")
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	file := writeTestFile(t, dir, "a.p12", content)
+
+	certificates, err := loadCertificates([]string{file}, nil, "P12", "secret")
+	assertCertificates(t, certificates, err, "client-a")
+
+	if _, err = loadCertificates([]string{file}, nil, "P12", "wrong"); err == nil {
+		t.Errorf("PKCS#12 file with wrong password must return an error!")
+	}
+	if _, err = loadCertificates([]string{file}, nil, "DER", "secret"); err == nil {
+		t.Errorf("Unknown certificate type must return an error!")
+	}
+}
+
+func assertCertificates(t *testing.T, certificates []tls.Certificate, err error, expectedNames ...string) {
+	if err != nil {
+		t.Errorf("loadCertificates has error: %v", err)
+		return
+	}
+	if len(certificates) != len(expectedNames) {
+		t.Errorf("loadCertificates has %d certificates, expected %d", len(certificates), len(expectedNames))
+		return
+	}
+	for i, certificate := range certificates {
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil || leaf.Subject.CommonName != expectedNames[i] {
+			t.Errorf("Certificate %d is invalid, expected %q: %v", i, expectedNames[i], err)
+		}
+	}
+}
+
+// loadTestCertificate of the self-signed fixture with certificate and key, shared with the tests of the engine
+func loadTestCertificate(t *testing.T, name string) testCertificate {
+	content, err := os.ReadFile(filepath.Join("testdata", name+".pem"))
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	certBlock, rest := pem.Decode(content)
+	keyBlock, _ := pem.Decode(rest)
+	if certBlock == nil || keyBlock == nil {
+		t.Fatalf("Setup test failed! %s is no certificate with key", name)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	return testCertificate{cert, key}
+}
+
+func (c testCertificate) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+}
+
+func (c testCertificate) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func writeTestFile(t *testing.T, dir, name string, content []byte) string {
+	filename := filepath.Join(dir, name)
+	if err := os.WriteFile(filename, content, 0600); err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	return filename
+}
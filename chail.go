@@ -1,252 +1,396 @@
 package main
 
 import (
-	"bytes"
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"fmt"
 	"io"
-	"net"
-	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"sort"
 	"strings"
-	"sync"
-	"time"
+	"syscall"
 
 	"github.com/fatih/color"
+	"github.com/mcjr/chail/engine"
 )
 
-type requestSample struct {
-	responseCode                 int
-	timeStartTransfer, timeTotal time.Duration
-}
-
-func (r requestSample) isSuccessful() bool {
-	if 199 < r.responseCode && r.responseCode < 300 {
-		return true
-	}
-	return false
-}
-
-type probeResult struct {
-	clients                                             int
-	avgTimeStartTransferNano, avgTimeTotalNano, errRate float64
-	responseCodeCount                                   map[int]int
-}
-
-func (p probeResult) String() string {
-	return fmt.Sprintf("%d: avg(starttransfer)=%.2fms, avg(total)=%.2fms, error=%.1f%%", p.clients, p.avgTimeStartTransferNano/1000000, p.avgTimeTotalNano/1000000, p.errRate*100)
-}
-
-var (
-	wg         sync.WaitGroup
-	client     http.Client
-	logEnabled bool
-)
+// exitInterrupted is the exit code if the run is interrupted by a signal
+const exitInterrupted = 130
 
 func main() {
 	config := ParseConfig(os.Stderr)
 	if config == nil {
 		os.Exit(1)
 	}
+	os.Exit(run(config))
+}
 
+// run the load test and report the results, returns the exit code
+func run(config *Config) int {
 	if config.NoColor {
 		color.NoColor = true
 	}
-	logEnabled = config.Verbose
 
 	err := config.Request.Build()
 	if err != nil {
 		color.Red(err.Error())
-		os.Exit(1)
+		return 1
 	}
 
 	color.Blue("GOMAXPROCS=%d", runtime.GOMAXPROCS(0))
 
-	initClient(config.NumClients, config.Timeout, config.Insecure, &config.CaCert)
-
-	process(config.Request, config.NumClients, config.NumRequests, config.Gradient)
-}
-
-func initClient(numClients int, timeout time.Duration, insecure bool, cacert *CaCert) {
-	transport := http.DefaultTransport.(*http.Transport)
-	transport.MaxConnsPerHost = numClients
-	if insecure {
-		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	printer := &stepPrinter{
+		errors:      os.Stderr,
+		gradient:    config.Gradient,
+		rateLimited: config.RatePerClient > 0 || config.MaxRPS > 0,
+		protocol:    config.Protocol != "",
+		dns:         config.DNS != "" || config.DNSServer != "",
+		connections: config.NoKeepAlive || config.ClientPools || config.MaxIdleConns > 0 || config.MaxConns > 0 || config.IdleTimeout > 0,
 	}
-	if cacert != nil && cacert.content != nil {
-		cacertPool := x509.NewCertPool()
-		cacertPool.AppendCertsFromPEM(cacert.content)
-		transport.TLSClientConfig = &tls.Config{RootCAs: cacertPool}
+	options := engine.Options{
+		Request:    config.Request,
+		NumClients: config.NumClients,
+		NumRepeats: config.NumRequests,
+		Stages:     config.Stages,
+		Timeout:    config.Timeout,
+		TLS:        config.TLS,
+		Handshakes: config.Handshakes,
+		Protocol:   config.Protocol,
+		Observers:  []engine.Observer{printer},
+
+		NoKeepAlive:  config.NoKeepAlive,
+		ClientPools:  config.ClientPools,
+		MaxIdleConns: config.MaxIdleConns,
+		MaxConns:     config.MaxConns,
+		IdleTimeout:  config.IdleTimeout,
+
+		Proxy:   config.Proxy,
+		NoProxy: config.NoProxy,
+
+		UnixSocket: config.UnixSocket,
+		Resolve:    config.Resolve,
+		ConnectTo:  config.ConnectTo,
+		LocalAddrs: config.LocalAddresses,
+		IPVersion:  ipVersion(config.IPv4, config.IPv6),
+		DNS:        config.DNS,
+		DNSServer:  config.DNSServer,
+
+		SpawnRate:   config.SpawnRate,
+		SpawnJitter: config.SpawnJitter,
+
+		ThinkTime: config.ThinkTime,
+		Pacing:    config.Pacing,
+
+		RatePerClient: config.RatePerClient,
+		MaxRPS:        config.MaxRPS,
+
+		MaxDuration: config.MaxDuration,
+		MaxRequests: config.MaxRequests,
+
+		WarmupDuration: config.WarmupDuration,
+		WarmupRequests: config.WarmupRequests,
+	}
+	if config.Verbose {
+		options.Log = func(msg string) { color.HiBlack(msg) }
 	}
 
-	client = http.Client{
-		Transport: transport,
-		Timeout:   timeout,
+	if config.Metrics != "" {
+		m := newMetrics()
+		server, err := serveMetrics(config.Metrics, m)
+		if err != nil {
+			color.Red(err.Error())
+			return 1
+		}
+		defer server.Close()
+		options.Observers = append(options.Observers, m)
+		color.Blue("Metrics on http://%s/metrics", config.Metrics)
 	}
-}
 
-func process(request Request, numClients, numRepeats int, accGradient float64) {
-	color.Cyan("Connecting to %s...", request.URL)
-	probes := make([]probeResult, 1, numClients+1)
-	for i := 1; i <= numClients; i++ {
-		probes = append(probes, exec(request, i, numRepeats))
-		fmt.Print(probes[i])
-		printGrad(&probes[i], &probes[i-1], accGradient)
-		if i > 10 {
-			printGrad(&probes[i], &probes[i-10], accGradient*10)
+	tags := newSinkTags(config.Scenario, config.Request.URL)
+	for _, spec := range config.Sinks {
+		s, err := newSink(spec, tags)
+		if err != nil {
+			color.Red(err.Error())
+			return 1
 		}
-		printResponseCodeCount(&probes[i])
-		fmt.Println()
+		defer s.close()
+		options.Observers = append(options.Observers, &sinkWriter{sink: s})
 	}
-}
 
-func printGrad(current *probeResult, previous *probeResult, m float64) {
-	if previous != nil && previous.avgTimeTotalNano != 0 {
-		grad := current.avgTimeTotalNano / previous.avgTimeTotalNano
-		dist := current.clients - previous.clients
-		fmt.Printf(", grad(%d)=", -dist)
-		switch {
-		case grad > 2.0*m:
-			color.Set(color.FgRed, color.Bold)
-		case grad > 1.6*m:
-			color.Set(color.FgRed)
-		case grad > 1.2*m:
-			color.Set(color.FgYellow)
-		case grad < 0.8*m:
-			color.Set(color.FgGreen)
+	if config.OTLPEndpoint != "" || config.TraceFile != "" {
+		target := config.OTLPEndpoint
+		if target == "" {
+			target = config.TraceFile
 		}
-		fmt.Printf("%.2f", grad)
-		color.Unset()
+		exporter, err := engine.NewSpanExporter(target)
+		if err != nil {
+			color.Red(err.Error())
+			return 1
+		}
+		options.Tracer = engine.NewTracer(config.Baggage, exporter)
+		defer func() {
+			if err := options.Tracer.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "exporting spans failed: %v\n", err)
+			}
+		}()
 	}
-}
 
-func printResponseCodeCount(current *probeResult) {
-	color.Set(color.FgHiBlack)
+	var live *dashboard
+	if config.Dashboard {
+		live = newDashboard(config.Request.URL)
+		options.Observers = append(options.Observers, live)
+		live.start()
+		printer.errors = live
+	}
 
-	codes := make([]int, 0, len(current.responseCodeCount))
-	for k := range current.responseCodeCount {
-		codes = append(codes, k)
+	ctx, cancel := interruptContext(printer.errors, func() {
+		if live != nil {
+			live.restore()
+		}
+	})
+	defer cancel()
+
+	color.Cyan("Connecting to %s...", config.Request.URL)
+	runner := engine.NewRunner(options)
+	var result engine.Result
+	if config.WarmupDuration > 0 || config.WarmupRequests > 0 {
+		var warmup engine.Probe
+		warmup, err = runner.Warmup(ctx)
+		printWarmup(&warmup)
 	}
-	sort.Ints(codes)
-	for _, code := range codes {
-		fmt.Printf(", rcc(%d)=%d", code, current.responseCodeCount[code])
+	if err == nil {
+		result, err = runner.Run(ctx)
 	}
-	color.Unset()
-}
+	probes := result.Probes
 
-func exec(request Request, numClients, numRepeat int) probeResult {
-	chanClientSample := make(chan []requestSample, numClients)
+	if live != nil {
+		live.stop()
+	}
+	if err != nil {
+		color.Yellow("Interrupted after %d completed steps", len(probes))
+	} else if result.LimitReached {
+		color.Yellow("Run limit reached after %d steps", len(probes))
+	}
 
-	for i := 0; i < numClients; i++ {
-		wg.Add(1)
-		go doClientRequests(request, numRepeat, chanClientSample)
+	if config.Chart && len(probes) > 0 {
+		printChart(color.Output, probes)
+		printHistogram(color.Output, &probes[len(probes)-1])
 	}
 
-	go func() {
-		wg.Wait()
-		close(chanClientSample)
-	}()
+	var violations []violation
+	if len(config.Assertions) > 0 {
+		violations = checkAssertions(config.Assertions, probes, config.AssertEachStep)
+		printAssertions(config.Assertions, probes, violations)
+	}
 
-	var sumTimStartTransfer, sumTimeTotal, successCount, errorCount int64
-	codeCount := make(map[int]int)
-	for clientSample := range chanClientSample {
-		for i := 0; i < numRepeat; i++ {
-			if clientSample[i].isSuccessful() {
-				successCount++
-				sumTimStartTransfer += clientSample[i].timeStartTransfer.Nanoseconds()
-				sumTimeTotal += clientSample[i].timeTotal.Nanoseconds()
-			} else {
-				errorCount++
-			}
-			codeCount[clientSample[i].responseCode] = codeCount[clientSample[i].responseCode] + 1
+	if config.JUnit != "" {
+		if err := writeJUnit(config.JUnit, config.Request.URL, config.Assertions, probes, violations); err != nil {
+			color.Red(err.Error())
 		}
 	}
 
-	return probeResult{
-		clients:                  numClients,
-		avgTimeStartTransferNano: float64(sumTimStartTransfer) / float64(successCount),
-		avgTimeTotalNano:         float64(sumTimeTotal) / float64(successCount),
-		errRate:                  float64(errorCount) / float64(numClients*numRepeat),
-		responseCodeCount:        codeCount,
+	switch {
+	case len(violations) > 0:
+		return exitAssertionFailed
+	case err != nil:
+		return exitInterrupted
 	}
+	return 0
 }
 
-func doClientRequests(request Request, numRepeat int, chanClientSample chan<- []requestSample) {
-	defer wg.Done()
-
-	clientSample := make([]requestSample, numRepeat)
-	for i := 0; i < numRepeat; i++ {
-		clientSample[i] = *doRequest(request)
+// interruptContext is cancelled by the first SIGINT or SIGTERM, a second one restores the terminal and exits immediately
+func interruptContext(output io.Writer, restore func()) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+		fmt.Fprintln(output, "interrupted, finishing the report (press Ctrl-C again to exit immediately)")
+		cancel()
+		<-signals
+		restore()
+		os.Exit(exitInterrupted)
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
 	}
-	chanClientSample <- clientSample
 }
 
-func doRequest(request Request) *requestSample {
-
-	result := requestSample{}
+// printWarmup prints the summary of the dropped warm-up samples
+func printWarmup(warmup *engine.Probe) {
+	color.Set(color.FgHiBlack)
+	fmt.Fprintf(color.Output, "warm-up: %d requests in %.1fs, avg(starttransfer)=%.2fms, avg(total)=%.2fms, error=%.1f%%%s\n",
+		warmup.Requests, warmup.Duration.Seconds(), warmup.AvgTimeStartTransferNano/1000000, warmup.AvgTimeTotalNano/1000000, warmup.ErrRate*100, formatResponseCodeCount(warmup))
+	color.Unset()
+}
 
-	req, _ := http.NewRequest(request.Method.String(), request.URL, bytes.NewBuffer(request.Body))
-	for key, values := range request.Header {
-		for _, value := range values {
-			req.Header.Add(key, value)
-		}
+// ipVersion of the forced address family, 0 for any
+func ipVersion(ipv4, ipv6 bool) int {
+	switch {
+	case ipv4:
+		return 4
+	case ipv6:
+		return 6
 	}
+	return 0
+}
 
-	logRequest(req)
-
-	start := time.Now()
-	resp, err := client.Do(req)
+// stepPrinter prints a line for every step and the errors of failed requests
+type stepPrinter struct {
+	engine.NopObserver
+	errors      io.Writer // failed requests
+	gradient    float64
+	rateLimited bool
+	protocol    bool // print protocols, connections and streams
+	connections bool // print new and reused connections
+	dns         bool // print DNS lookups
+	probes      []engine.Probe
+}
 
-	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-		fmt.Fprintf(os.Stderr, "timeout fetching: %v\n", err)
-		return &result
-	} else if err != nil {
-		fmt.Fprintf(os.Stderr, "fetching failed: %v\n", err)
-		return &result
+func (p *stepPrinter) RequestDone(sample engine.Sample) {
+	switch sample.Failure {
+	case engine.FailureTimeout:
+		fmt.Fprintf(p.errors, "timeout fetching: %v\n", sample.Err)
+	case engine.FailureFetch:
+		fmt.Fprintf(p.errors, "fetching failed: %v\n", sample.Err)
+	case engine.FailureRead:
+		fmt.Fprintf(p.errors, "reading failed: %v\n", sample.Err)
+	case engine.FailurePorts:
+		fmt.Fprintf(p.errors, "no free local port: %v\n", sample.Err)
+	case engine.FailureProto:
+		fmt.Fprintf(p.errors, "protocol failed: %v\n", sample.Err)
+	case engine.FailureDNS:
+		fmt.Fprintf(p.errors, "resolving failed: %v\n", sample.Err)
 	}
-	defer resp.Body.Close()
-
-	result.responseCode = resp.StatusCode
-	result.timeStartTransfer = time.Since(start)
+}
 
-	body, bodyErr := io.ReadAll(resp.Body)
-	if bodyErr != nil {
-		fmt.Fprintf(os.Stderr, "reading failed: %v\n", bodyErr)
-		return &result
+func (p *stepPrinter) StepDone(probe engine.Probe) {
+	if len(p.probes) == 0 {
+		p.probes = append(p.probes, engine.Probe{})
+	}
+	p.probes = append(p.probes, probe)
+	i := len(p.probes) - 1
+	fmt.Fprint(color.Output, probe)
+	printGrad(&p.probes[i], &p.probes[i-1], p.gradient)
+	if i > 10 {
+		printGrad(&p.probes[i], &p.probes[i-10], p.gradient*10)
+	}
+	if p.rateLimited {
+		fmt.Fprintf(color.Output, ", rps=%.1f, wait(limiter)=%.2fs", probe.RPS(), probe.LimiterWait.Seconds())
+	}
+	printResponseCodeCount(&p.probes[i])
+	printTLS(&p.probes[i])
+	if p.protocol {
+		color.Set(color.FgHiBlack)
+		fmt.Fprint(color.Output, formatProtocols(&probe))
+		color.Unset()
+	}
+	if p.connections {
+		color.Set(color.FgHiBlack)
+		fmt.Fprintf(color.Output, ", conns(new)=%d, conns(reused)=%d", probe.NewConns, probe.ReusedConns)
+		color.Unset()
+	}
+	if p.dns {
+		color.Set(color.FgHiBlack)
+		fmt.Fprintf(color.Output, ", dns=%d, avg(dns)=%.2fms", probe.DNSLookups, probe.AvgDNSNano/1e6)
+		color.Unset()
 	}
+	fmt.Fprintln(color.Output)
+}
 
-	result.timeTotal = time.Since(start)
+func printGrad(current *engine.Probe, previous *engine.Probe, m float64) {
+	if previous != nil && previous.AvgTimeTotalNano != 0 {
+		grad := current.AvgTimeTotalNano / previous.AvgTimeTotalNano
+		dist := current.Clients - previous.Clients
+		fmt.Fprintf(color.Output, ", grad(%d)=", -dist)
+		gradColor(grad, m).Fprintf(color.Output, "%.2f", grad)
+	}
+}
 
-	logReponse(resp, body)
+// gradColor is the fixed representation of gradient values in relation to m
+func gradColor(grad, m float64) *color.Color {
+	switch {
+	case grad > 2.0*m:
+		return color.New(color.FgRed, color.Bold)
+	case grad > 1.6*m:
+		return color.New(color.FgRed)
+	case grad > 1.2*m:
+		return color.New(color.FgYellow)
+	case grad < 0.8*m:
+		return color.New(color.FgGreen)
+	}
+	return color.New(color.Reset)
+}
 
-	return &result
+func printResponseCodeCount(current *engine.Probe) {
+	color.Set(color.FgHiBlack)
+	fmt.Fprint(color.Output, formatResponseCodeCount(current))
+	color.Unset()
 }
 
-func logRequest(req *http.Request) {
-	logVerbose("> " + req.Method + " " + req.URL.RequestURI() + " " + req.Proto)
-	logVerbose("> Host: " + req.URL.Host)
-	logVerboseHeader("> ", req.Header)
-	logVerbose(">")
+func printTLS(current *engine.Probe) {
+	color.Set(color.FgHiBlack)
+	fmt.Fprint(color.Output, formatTLS(current))
+	color.Unset()
 }
 
-func logReponse(resp *http.Response, body []byte) {
-	logVerbose("< " + resp.Proto + " " + resp.Status)
-	logVerboseHeader("< ", resp.Header)
-	logVerbose("<")
-	logVerbose(string(body))
+// formatTLS of the requests by negotiated version and cipher suite and the full and resumed handshakes
+func formatTLS(current *engine.Probe) string {
+	names := make([]string, 0, len(current.TLSCount))
+	for name := range current.TLSCount {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var s strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&s, ", tls(%s)=%d", name, current.TLSCount[name])
+	}
+	if current.Handshakes > 0 {
+		fmt.Fprintf(&s, ", resumed=%d/%d", current.ResumedHandshakes, current.Handshakes)
+		if current.ZeroRTT > 0 {
+			fmt.Fprintf(&s, ", 0-rtt=%d", current.ZeroRTT)
+		}
+		full, resumed := current.HandshakeRates()
+		if current.Handshakes > current.ResumedHandshakes {
+			fmt.Fprintf(&s, ", handshake(full)=%.2fms %.1f/s", current.AvgHandshakeNano/1000000, full)
+		}
+		if current.ResumedHandshakes > 0 {
+			fmt.Fprintf(&s, ", handshake(resumed)=%.2fms %.1f/s", current.AvgResumedHandshakeNano/1000000, resumed)
+		}
+	}
+	return s.String()
 }
 
-func logVerboseHeader(prefix string, header http.Header) {
-	for key, values := range header {
-		logVerbose(prefix + key + ": " + strings.Join(values, " "))
+// formatProtocols of the responses and the connections against the concurrent streams
+func formatProtocols(current *engine.Probe) string {
+	protos := make([]string, 0, len(current.ProtoCount))
+	for proto := range current.ProtoCount {
+		protos = append(protos, proto)
 	}
+	sort.Strings(protos)
+	var s strings.Builder
+	for _, proto := range protos {
+		fmt.Fprintf(&s, ", proto(%s)=%d", proto, current.ProtoCount[proto])
+	}
+	fmt.Fprintf(&s, ", conns=%d, streams(max)=%d", current.Connections, current.MaxStreams)
+	return s.String()
 }
 
-func logVerbose(msg string) {
-	if logEnabled {
-		color.HiBlack(msg)
+func formatResponseCodeCount(current *engine.Probe) string {
+	codes := make([]int, 0, len(current.ResponseCodeCount))
+	for k := range current.ResponseCodeCount {
+		codes = append(codes, k)
+	}
+	sort.Ints(codes)
+	var s strings.Builder
+	for _, code := range codes {
+		fmt.Fprintf(&s, ", rcc(%d)=%d", code, current.ResponseCodeCount[code])
 	}
+	return s.String()
 }
@@ -1,175 +1,67 @@
 package main
 
 import (
-	"encoding/pem"
-	"io"
-	"net/http"
-	"net/http/httptest"
+	"bytes"
 	"strings"
 	"testing"
 	"time"
-)
 
-var (
-	config Config
+	"github.com/fatih/color"
+	"github.com/mcjr/chail/engine"
 )
 
-func TestProcess(t *testing.T) {
-	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
-	server := startServer(t, "Content-Type", "application/json")
-	defer server.Close()
-
-	process(config.Request, 11, 1, 1.1)
-}
-
-func TestProcessWithErrors(t *testing.T) {
-	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
-	server := startResponseCodeServer(429)
-	defer server.Close()
-
-	process(config.Request, 1, 1, 1.1)
-}
-
-func TestExec(t *testing.T) {
-	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
-	server := startServer(t, "Content-Type", "application/json")
-	defer server.Close()
+func TestStepPrinter(t *testing.T) {
+	var buf bytes.Buffer
+	output, noColor := color.Output, color.NoColor
+	color.Output, color.NoColor = &buf, true
+	defer func() { color.Output, color.NoColor = output, noColor }()
 
-	probe := exec(config.Request, 2, 2)
-	if probe.clients != 2 || probe.errRate > 0.0 {
-		t.Errorf("exec fails, expected %d clients %f error rate, but was %d clients and %f error rate!", 2, 0.0, probe.clients, probe.errRate)
+	printer := &stepPrinter{gradient: 1.1}
+	for i := 1; i <= 11; i++ {
+		printer.StepDone(engine.Probe{Clients: i, AvgTimeStartTransferNano: float64(i) * 1e6, AvgTimeTotalNano: float64(i) * 2e6, ResponseCodeCount: map[int]int{200: i}})
 	}
-}
-
-func TestDoRequestTLS(t *testing.T) {
-	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
-
-	server := startTLSServer(t, "Content-Type", "application/xml")
-	defer server.Close()
 
-	if !strings.HasPrefix(server.URL, "https:") {
-		t.Errorf("Expected protocol %q, but URL is %q", "https:", server.URL)
-	}
-
-	certContent := server.TLS.Certificates[0].Certificate[0]
-	pemContent := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certContent})
-	cacert := CaCert{pemContent}
-
-	initClient(1, time.Duration(1*time.Second), false, &cacert)
-
-	sample := doRequest(config.Request)
-	if !sample.isSuccessful() {
-		t.Errorf("doRequest fails: %s %s", config.Request.Method.String(), server.URL)
-	}
+	lines := strings.Split(buf.String(), "\n")
+	assertStepLine(t, lines[0], "1: avg(starttransfer)=1.00ms, avg(total)=2.00ms, error=0.0%, rcc(200)=1")
+	assertStepLine(t, lines[1], "2: avg(starttransfer)=2.00ms, avg(total)=4.00ms, error=0.0%, grad(-1)=2.00, rcc(200)=2")
+	assertStepLine(t, lines[10], "11: avg(starttransfer)=11.00ms, avg(total)=22.00ms, error=0.0%, grad(-1)=1.10, grad(-10)=11.00, rcc(200)=11")
 }
 
-func TestDoRequestInsecureTLS(t *testing.T) {
-	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+func TestStepPrinterRateLimited(t *testing.T) {
+	var buf bytes.Buffer
+	output, noColor := color.Output, color.NoColor
+	color.Output, color.NoColor = &buf, true
+	defer func() { color.Output, color.NoColor = output, noColor }()
 
-	server := startTLSServer(t, "Content-Type", "application/xml")
-	defer server.Close()
+	printer := &stepPrinter{gradient: 1.1, rateLimited: true}
+	printer.StepDone(engine.Probe{Clients: 2, Requests: 20, Duration: 2 * time.Second, LimiterWait: 1500 * time.Millisecond, ResponseCodeCount: map[int]int{200: 20}})
 
-	if !strings.HasPrefix(server.URL, "https:") {
-		t.Errorf("Expected protocol %q, but URL is %q", "https:", server.URL)
-	}
-
-	initClient(1, time.Duration(1*time.Second), true, nil)
-
-	sample := doRequest(config.Request)
-	if !sample.isSuccessful() {
-		t.Errorf("doRequest fails: %s %s", config.Request.Method.String(), server.URL)
-	}
+	assertStepLine(t, strings.TrimSuffix(buf.String(), "\n"), "2: avg(starttransfer)=0.00ms, avg(total)=0.00ms, error=0.0%, rps=10.0, wait(limiter)=1.50s, rcc(200)=20")
 }
 
-func TestDoRequestGET(t *testing.T) {
-	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
-	server := startServer(t, "Content-Type", "application/xml")
-	defer server.Close()
-
-	sample := doRequest(config.Request)
-	if !sample.isSuccessful() {
-		t.Errorf("doRequest fails: %s %s", config.Request.Method.String(), server.URL)
+func TestFormatTLS(t *testing.T) {
+	probe := engine.Probe{TLSCount: map[string]int{"TLS 1.3 TLS_AES_128_GCM_SHA256": 8, "TLS 1.2 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256": 2}, Handshakes: 4, ResumedHandshakes: 3,
+		AvgHandshakeNano: 4000000, AvgResumedHandshakeNano: 1000000, Duration: 2 * time.Second, ZeroRTT: 2}
+	expected := ", tls(TLS 1.2 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)=2, tls(TLS 1.3 TLS_AES_128_GCM_SHA256)=8, resumed=3/4, 0-rtt=2" +
+		", handshake(full)=4.00ms 0.5/s, handshake(resumed)=1.00ms 1.5/s"
+	if s := formatTLS(&probe); s != expected {
+		t.Errorf("formatTLS is %q, expected %q", s, expected)
 	}
-}
-
-func TestDoRequestGETButClientError(t *testing.T) {
-	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
-	server := startResponseCodeServer(400)
-	defer server.Close()
-
-	sample := doRequest(config.Request)
-	if sample.isSuccessful() {
-		t.Errorf("doRequest should fail with response code 400: %d %d", 400, sample.responseCode)
+	if s := formatTLS(&engine.Probe{}); s != "" {
+		t.Errorf("formatTLS without TLS must be empty, but was %q", s)
 	}
 }
 
-func TestDoRequestPOST(t *testing.T) {
-	setUp("POST", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
-	server := startServer(t, "Content-Type", "application/json")
-	defer server.Close()
-
-	sample := doRequest(config.Request)
-	if !sample.isSuccessful() {
-		t.Errorf("doRequest fails: %s %s", config.Request.Method.String(), server.URL)
+func TestFormatProtocols(t *testing.T) {
+	probe := engine.Probe{ProtoCount: map[string]int{"HTTP/2.0": 90, "HTTP/1.1": 10}, Connections: 3, MaxStreams: 40}
+	expected := ", proto(HTTP/1.1)=10, proto(HTTP/2.0)=90, conns=3, streams(max)=40"
+	if s := formatProtocols(&probe); s != expected {
+		t.Errorf("formatProtocols is %q, expected %q", s, expected)
 	}
 }
 
-func setUp(method, headerLine, data string) {
-	config.Request.Method.Set(method)
-	config.Request.Header = make(Header)
-	config.Request.Header.Set(headerLine)
-	config.Request.Data.Set(data)
-	config.Request.Build()
-}
-
-func startResponseCodeServer(responseCode int) *httptest.Server {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(responseCode) }))
-	config.Request.URL = ts.URL
-	return ts
-}
-
-func startServer(t *testing.T, key, value string) *httptest.Server {
-	th := TestHandler{t, key, value}
-	ts := httptest.NewServer(http.HandlerFunc(th.handle))
-	config.Request.URL = ts.URL
-	return ts
-}
-
-func startTLSServer(t *testing.T, key, value string) *httptest.Server {
-	th := TestHandler{t, key, value}
-	ts := httptest.NewTLSServer(http.HandlerFunc(th.handle))
-	config.Request.URL = ts.URL
-	return ts
-}
-
-type TestHandler struct {
-	*testing.T
-	key, value string
-}
-
-func (t *TestHandler) handle(w http.ResponseWriter, r *http.Request) {
-	if r.Method != config.Request.Method.String() {
-		t.Errorf("Request has method %s, but expected %s", r.Method, config.Request.Method.String())
-		http.Error(w, "invalid method", http.StatusBadRequest)
-		return
+func assertStepLine(t *testing.T, line, expected string) {
+	if line != expected {
+		t.Errorf("Step line is %q, expected %q", line, expected)
 	}
-	if r.Header[t.key][0] != t.value {
-		t.Errorf("Expected header %q with value %q but got %v!", t.key, t.value, r.Header)
-		http.Error(w, "invalid header", http.StatusBadRequest)
-		return
-	}
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		t.Errorf("Error reading request body: %v", err)
-		http.Error(w, "can't read body", http.StatusBadRequest)
-		return
-	}
-	if string(body) != config.Request.Data.String() {
-		t.Errorf("Expected body %s, but was %s", string(body), config.Request.Data.String())
-		http.Error(w, "invalid body", http.StatusBadRequest)
-		return
-	}
-}
-func (t *TestHandler) handleError(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "custom error", http.StatusBadRequest)
 }
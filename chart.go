@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/mcjr/chail/engine"
+)
+
+const (
+	chartHeight          = 10
+	chartPercentile      = 95
+	histogramBuckets     = 10
+	histogramBarMaxWidth = 40
+)
+
+// printChart of avg and percentile total time against the number of clients
+func printChart(w io.Writer, probes []engine.Probe) {
+	if len(probes) == 0 {
+		return
+	}
+	avgs := make([]float64, len(probes))
+	percentiles := make([]float64, len(probes))
+	var max float64
+	for i := range probes {
+		avgs[i] = probes[i].AvgTimeTotalNano / 1000000
+		percentiles[i] = probes[i].Percentile(chartPercentile) / 1000000
+		if avgs[i] > max {
+			max = avgs[i]
+		}
+		if percentiles[i] > max {
+			max = percentiles[i]
+		}
+	}
+	if max == 0 {
+		return
+	}
+
+	avgColor := color.New(color.FgCyan)
+	percentileColor := color.New(color.FgYellow)
+	fmt.Fprintf(w, "total time [ms] against clients: %s avg, %s p%d\n", avgColor.Sprint("●"), percentileColor.Sprint("·"), chartPercentile)
+	for row := chartHeight; row > 0; row-- {
+		fmt.Fprintf(w, "%9.2f │", max*float64(row)/chartHeight)
+		for i := range probes {
+			switch {
+			case chartRow(avgs[i], max) == row:
+				avgColor.Fprint(w, "●")
+			case chartRow(percentiles[i], max) == row:
+				percentileColor.Fprint(w, "·")
+			default:
+				fmt.Fprint(w, " ")
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%9s └%s\n", "", strings.Repeat("─", len(probes)))
+	first, last := fmt.Sprint(probes[0].Clients), fmt.Sprint(probes[len(probes)-1].Clients)
+	gap := len(probes) - len(first) - len(last)
+	if gap < 1 {
+		gap = 1
+	}
+	fmt.Fprintf(w, "%9s  %s%s%s\n", "", first, strings.Repeat(" ", gap), last)
+}
+
+// chartRow of a value between 1 and chartHeight, 0 for values not present
+func chartRow(value, max float64) int {
+	if value <= 0 || math.IsNaN(value) {
+		return 0
+	}
+	row := int(value/max*chartHeight + 0.5)
+	if row < 1 {
+		row = 1
+	}
+	return row
+}
+
+// printHistogram of total time of the probe, colored like the gradient in relation to the average
+func printHistogram(w io.Writer, probe *engine.Probe) {
+	if len(probe.TimeTotalNanos) == 0 {
+		return
+	}
+	min := probe.TimeTotalNanos[0]
+	width := (probe.TimeTotalNanos[len(probe.TimeTotalNanos)-1]-min)/histogramBuckets + 1
+	counts := make([]int, histogramBuckets)
+	maxCount := 0
+	for _, t := range probe.TimeTotalNanos {
+		bucket := int((t - min) / width)
+		counts[bucket]++
+		if counts[bucket] > maxCount {
+			maxCount = counts[bucket]
+		}
+	}
+
+	fmt.Fprintf(w, "total time [ms] of %d clients:\n", probe.Clients)
+	for i, count := range counts {
+		lower := float64(min + int64(i)*width)
+		upper := float64(min + int64(i+1)*width)
+		bar := strings.Repeat("█", (count*histogramBarMaxWidth+maxCount-1)/maxCount)
+		fmt.Fprintf(w, "%9.2f - %9.2f │", lower/1000000, upper/1000000)
+		gradColor((lower+upper)/2/probe.AvgTimeTotalNano, 1.0).Fprint(w, bar)
+		fmt.Fprintf(w, " %d\n", count)
+	}
+}
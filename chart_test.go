@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/mcjr/chail/engine"
+)
+
+func TestPrintChart(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	probes := []engine.Probe{
+		{Clients: 1, AvgTimeTotalNano: 10e6, TimeTotalNanos: []int64{10e6, 20e6}},
+		{Clients: 2, AvgTimeTotalNano: 50e6, TimeTotalNanos: []int64{40e6, 100e6}},
+	}
+	var buf bytes.Buffer
+	printChart(&buf, probes)
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) != chartHeight+4 {
+		t.Errorf("Chart has %d lines, expected %d:\n%s", len(lines), chartHeight+4, buf.String())
+		return
+	}
+	assertChartLine(t, lines[1], "   100.00 │ ·")
+	assertChartLine(t, lines[6], "    50.00 │ ●")
+	assertChartLine(t, lines[9], "    20.00 │· ")
+	assertChartLine(t, lines[10], "    10.00 │● ")
+	assertChartLine(t, lines[12], "           1 2")
+}
+
+func assertChartLine(t *testing.T, line, expected string) {
+	if line != expected {
+		t.Errorf("Chart line is %q, expected %q", line, expected)
+	}
+}
+
+func TestPrintHistogram(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	probe := engine.Probe{Clients: 3, AvgTimeTotalNano: 30, TimeTotalNanos: []int64{10, 10, 10, 10, 59}}
+	var buf bytes.Buffer
+	printHistogram(&buf, &probe)
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) != histogramBuckets+2 {
+		t.Errorf("Histogram has %d lines, expected %d:\n%s", len(lines), histogramBuckets+2, buf.String())
+		return
+	}
+	if !strings.HasSuffix(lines[1], "│"+strings.Repeat("█", histogramBarMaxWidth)+" 4") {
+		t.Errorf("Histogram has invalid first bucket: %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[10], "│"+strings.Repeat("█", histogramBarMaxWidth/4)+" 1") {
+		t.Errorf("Histogram has invalid last bucket: %q", lines[10])
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mcjr/chail/engine"
+)
+
+const (
+	dashboardRefresh    = 250 * time.Millisecond
+	dashboardRateWindow = 5 * time.Second
+	dashboardSparkWidth = 60
+	dashboardMaxSteps   = 15
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// dashboard is a full screen live view of a run, fed by the observer notifications
+type dashboard struct {
+	engine.NopObserver
+	mu                  sync.Mutex
+	url                 string
+	clients, inFlight   int
+	completions         []time.Time
+	tickSum             time.Duration
+	tickCount           int
+	sparkline           []time.Duration
+	responseCodeCount   map[int]int
+	probes              []engine.Probe
+	lastError           string
+	terminal            io.Writer
+	buffer              lockedBuffer
+	restored            bool
+	done, finished      chan struct{}
+	now                 func() time.Time
+	startedAt, tickedAt time.Time
+}
+
+func newDashboard(url string) *dashboard {
+	return &dashboard{
+		url:               url,
+		responseCodeCount: make(map[int]int),
+		now:               time.Now,
+	}
+}
+
+// start switches to the alternate screen and buffers regular output until stop
+func (d *dashboard) start() {
+	d.terminal = color.Output
+	color.Output = &d.buffer
+	d.done = make(chan struct{})
+	d.finished = make(chan struct{})
+	d.startedAt = d.now()
+	d.tickedAt = d.startedAt
+	fmt.Fprint(d.terminal, "\033[?1049h\033[?25l")
+
+	go func() {
+		defer close(d.finished)
+		ticker := time.NewTicker(dashboardRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.tick()
+				d.render(d.terminal)
+			case <-d.done:
+				return
+			}
+		}
+	}()
+}
+
+// stop restores the screen and writes the buffered regular output
+func (d *dashboard) stop() {
+	close(d.done)
+	<-d.finished
+	d.restore()
+	color.Output = d.terminal
+}
+
+// restore leaves the alternate screen and writes the buffered output, also when exiting without stop
+func (d *dashboard) restore() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.restored {
+		return
+	}
+	d.restored = true
+	fmt.Fprint(d.terminal, "\033[?25h\033[?1049l")
+	d.terminal.Write(d.buffer.Bytes())
+}
+
+// Write error output to the buffer and show its last line on the screen
+func (d *dashboard) Write(p []byte) (int, error) {
+	d.buffer.Write(p)
+	lines := strings.Split(strings.TrimSpace(string(p)), "\n")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastError = lines[len(lines)-1]
+	return len(p), nil
+}
+
+// lockedBuffer is safe for concurrent writes of the clients
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes written so far
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+func (d *dashboard) StepStarted(clients int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clients = clients
+}
+
+func (d *dashboard) RequestStarted() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight++
+}
+
+func (d *dashboard) RequestDone(sample engine.Sample) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	d.completions = append(d.completions, d.now())
+	d.responseCodeCount[sample.ResponseCode]++
+	if sample.IsSuccessful() {
+		d.tickSum += sample.TimeTotal
+		d.tickCount++
+	}
+}
+
+func (d *dashboard) StepDone(probe engine.Probe) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.probes = append(d.probes, probe)
+}
+
+// tick closes the current sparkline interval and drops completions outside the rate window
+func (d *dashboard) tick() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var avg time.Duration
+	if d.tickCount > 0 {
+		avg = d.tickSum / time.Duration(d.tickCount)
+	}
+	d.sparkline = append(d.sparkline, avg)
+	if len(d.sparkline) > dashboardSparkWidth {
+		d.sparkline = d.sparkline[len(d.sparkline)-dashboardSparkWidth:]
+	}
+	d.tickSum, d.tickCount = 0, 0
+
+	d.tickedAt = d.now()
+	i := sort.Search(len(d.completions), func(i int) bool { return d.tickedAt.Sub(d.completions[i]) <= dashboardRateWindow })
+	d.completions = d.completions[i:]
+}
+
+// rate of completed requests per second within the rate window
+func (d *dashboard) rate() float64 {
+	window := d.tickedAt.Sub(d.startedAt)
+	if window > dashboardRateWindow {
+		window = dashboardRateWindow
+	}
+	if window <= 0 {
+		return 0
+	}
+	return float64(len(d.completions)) / window.Seconds()
+}
+
+func (d *dashboard) render(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.restored {
+		return
+	}
+
+	var s strings.Builder
+	s.WriteString("\033[H\033[2J")
+	s.WriteString(color.CyanString("chail %s", d.url) + "\n\n")
+	fmt.Fprintf(&s, "clients: %d   in-flight: %d   req/s: %.1f\n", d.clients, d.inFlight, d.rate())
+
+	var max time.Duration
+	for _, v := range d.sparkline {
+		if v > max {
+			max = v
+		}
+	}
+	spark := make([]rune, len(d.sparkline))
+	for i, v := range d.sparkline {
+		switch {
+		case v == 0:
+			spark[i] = ' '
+		default:
+			spark[i] = sparkBlocks[int(v*time.Duration(len(sparkBlocks)-1)/max)]
+		}
+	}
+	fmt.Fprintf(&s, "latency: %s max=%.2fms\n", color.GreenString(string(spark)), float64(max)/1000000)
+
+	codes := make([]int, 0, len(d.responseCodeCount))
+	for code := range d.responseCodeCount {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	terms := make([]string, len(codes))
+	for i, code := range codes {
+		terms[i] = fmt.Sprintf("rcc(%d)=%d", code, d.responseCodeCount[code])
+	}
+	s.WriteString(color.HiBlackString(strings.Join(terms, ", ")) + "\n")
+	if d.lastError != "" {
+		s.WriteString(color.RedString("last error: %s", d.lastError) + "\n")
+	}
+	s.WriteString("\n")
+
+	fmt.Fprintf(&s, "%8s %20s %14s %8s %10s\n", "clients", "avg(starttransfer)", "avg(total)", "error", "req/s")
+	probes := d.probes
+	if len(probes) > dashboardMaxSteps {
+		probes = probes[len(probes)-dashboardMaxSteps:]
+	}
+	for _, p := range probes {
+		fmt.Fprintf(&s, "%8d %18.2fms %12.2fms %7.1f%% %10.1f\n", p.Clients, p.AvgTimeStartTransferNano/1000000, p.AvgTimeTotalNano/1000000, p.ErrRate*100, p.RPS())
+	}
+	io.WriteString(w, s.String())
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mcjr/chail/engine"
+)
+
+func TestDashboardRender(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := newDashboard("http://localhost:8080")
+	d.now = func() time.Time { return now }
+	d.startedAt = now
+
+	d.StepStarted(2)
+	d.RequestStarted()
+	d.RequestStarted()
+	d.RequestStarted()
+	now = now.Add(time.Second)
+	d.RequestDone(engine.Sample{ResponseCode: 200, TimeTotal: 10 * time.Millisecond})
+	d.RequestDone(engine.Sample{ResponseCode: 500})
+	d.tick()
+	d.RequestDone(engine.Sample{ResponseCode: 200, TimeTotal: 20 * time.Millisecond})
+	d.tick()
+	d.StepDone(engine.Probe{Clients: 2, Requests: 4, AvgTimeTotalNano: 15e6, ErrRate: 0.25, Duration: time.Second})
+
+	var buf bytes.Buffer
+	d.render(&buf)
+	for _, expected := range []string{
+		"clients: 2   in-flight: 0   req/s: 3.0",
+		"latency: ▄█ max=20.00ms",
+		"rcc(200)=2, rcc(500)=1",
+		"       2               0.00ms        15.00ms    25.0%        4.0",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Dashboard misses %q in:\n%s", expected, buf.String())
+		}
+	}
+}
+
+func TestDashboardBuffersOutput(t *testing.T) {
+	var terminal bytes.Buffer
+	output := color.Output
+	color.Output = &terminal
+	defer func() { color.Output = output }()
+
+	d := newDashboard("http://localhost:8080")
+	d.start()
+	color.New().Fprint(color.Output, "step")
+	if strings.Contains(terminal.String(), "step") {
+		t.Errorf("Dashboard must buffer regular output while running")
+	}
+	d.stop()
+	if !strings.HasSuffix(terminal.String(), "step") || color.Output != &terminal {
+		t.Errorf("Dashboard must restore regular output when stopped, but got %q", terminal.String())
+	}
+}
+
+func TestDashboardErrors(t *testing.T) {
+	var terminal lockedBuffer
+	output := color.Output
+	color.Output = &terminal
+	defer func() { color.Output = output }()
+
+	d := newDashboard("http://localhost:8080")
+	d.start()
+	printer := &stepPrinter{errors: d}
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			printer.RequestDone(engine.Sample{Failure: engine.FailureFetch, Err: errors.New("connection refused")})
+			color.New().Fprintln(color.Output, "> GET /")
+		}()
+	}
+	wg.Wait()
+
+	var screen bytes.Buffer
+	d.render(&screen)
+	if !strings.Contains(screen.String(), "last error: fetching failed: connection refused") {
+		t.Errorf("Dashboard must show the last error, but got:\n%s", screen.String())
+	}
+	if strings.Contains(string(terminal.Bytes()), "fetching failed") {
+		t.Errorf("Errors must be buffered while the dashboard is running")
+	}
+	d.stop()
+	if strings.Count(string(terminal.Bytes()), "fetching failed") != 10 || strings.Count(string(terminal.Bytes()), "> GET /") != 10 {
+		t.Errorf("Dashboard must write the buffered errors and output when stopped, but got %q", string(terminal.Bytes()))
+	}
+}
+
+func TestDashboardRestore(t *testing.T) {
+	var terminal bytes.Buffer
+	output := color.Output
+	color.Output = &terminal
+	defer func() { color.Output = output }()
+
+	d := newDashboard("http://localhost:8080")
+	d.start()
+	d.restore()
+	if !strings.Contains(terminal.String(), "\033[?25h\033[?1049l") {
+		t.Errorf("Dashboard must leave the alternate screen and show the cursor, but got %q", terminal.String())
+	}
+	d.stop()
+	if strings.Count(terminal.String(), "\033[?1049l") != 1 {
+		t.Errorf("Terminal must be restored once, but got %q", terminal.String())
+	}
+}
@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// source addresses of a client, one per address family, nil for any
+type source struct {
+	ipv4, ipv6 net.IP
+}
+
+// newSources pairs the IPv4 and IPv6 source addresses, rotated across the clients, a single source for any if none
+func newSources(addrs []net.IP) []source {
+	var ipv4, ipv6 []net.IP
+	for _, addr := range addrs {
+		if addr.To4() != nil {
+			ipv4 = append(ipv4, addr)
+		} else {
+			ipv6 = append(ipv6, addr)
+		}
+	}
+	sources := make([]source, max(len(ipv4), len(ipv6), 1))
+	for i := range sources {
+		if len(ipv4) > 0 {
+			sources[i].ipv4 = ipv4[i%len(ipv4)]
+		}
+		if len(ipv6) > 0 {
+			sources[i].ipv6 = ipv6[i%len(ipv6)]
+		}
+	}
+	return sources
+}
+
+// dual is true if the source has addresses of both families, so that the family of the remote address must be known before the dial
+func (s source) dual() bool {
+	return s.ipv4 != nil && s.ipv6 != nil
+}
+
+// localAddr of the family of the remote host, the address of the other family if the source has none
+func (s source) localAddr(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil && s.ipv6 != nil || s.ipv4 == nil {
+		return s.ipv6
+	}
+	return s.ipv4
+}
+
+// originKey of the host and port of the URL in the context of a request, connect-to and resolve apply to it only
+type originKey struct{}
+
+// origin host and port of the URL with the default port of the scheme
+func origin(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// dial the address of a request with the unix socket, the connect-to targets, the resolved addresses and the DNS strategy
+// of the options from the source address of the client
+func (r *Runner) dial(ctx context.Context, src source, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if r.options.UnixSocket != "" {
+		return dialer.DialContext(ctx, "unix", r.options.UnixSocket)
+	}
+	if o, ok := ctx.Value(originKey{}).(string); !ok || o == addr {
+		// not the proxy
+		addr = r.target(addr)
+	}
+	addrs, err := r.resolve(ctx, addr, src.dual())
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	for _, addr := range addrs {
+		dialer.LocalAddr = nil
+		if host, _, err := net.SplitHostPort(addr); err == nil && src.localAddr(host) != nil {
+			dialer.LocalAddr = &net.TCPAddr{IP: src.localAddr(host)}
+		}
+		if conn, err = dialer.DialContext(ctx, r.network(network), addr); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// network of the forced address family, e.g. tcp4 for tcp and IPVersion 4
+func (r *Runner) network(network string) string {
+	if r.options.IPVersion == 0 {
+		return network
+	}
+	return strings.TrimRight(network, "46") + strconv.Itoa(r.options.IPVersion)
+}
+
+// target of the address after connect-to and resolve, several resolved addresses are used round-robin
+func (r *Runner) target(addr string) string {
+	addr = r.options.ConnectTo.target(addr)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	addrs := r.options.Resolve[net.JoinHostPort(host, port)]
+	if len(addrs) == 0 {
+		return addr
+	}
+	return net.JoinHostPort(addrs[int(r.resolved.Add(1)-1)%len(addrs)], port)
+}
+
+// portsExhausted is true if the connection failed for lack of a free local port or of the source address
+func portsExhausted(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EADDRINUSE)
+}
+
+// Resolves host and port to addresses from arguments
+type Resolves map[string][]string
+
+func (r *Resolves) String() string {
+	terms := make([]string, 0, len(*r))
+	for hostPort, addrs := range *r {
+		bracketed := make([]string, len(addrs))
+		for i, addr := range addrs {
+			bracketed[i] = bracket(addr)
+		}
+		terms = append(terms, hostPort+":"+strings.Join(bracketed, ","))
+	}
+	sort.Strings(terms)
+	return strings.Join(terms, " ")
+}
+
+// Set Resolves from argument, e.g. 'example.com:443:10.0.0.1,10.0.0.2' or 'example.com:443:[::1]'
+func (r *Resolves) Set(s string) error {
+	host, rest, ok := cutField(s)
+	port, list, ok2 := cutField(rest)
+	if !ok || !ok2 || host == "" || port == "" || list == "" {
+		return fmt.Errorf("invalid resolve %q, expected <host>:<port>:<addr>[,<addr>...]", s)
+	}
+	var addrs []string
+	for _, addr := range strings.Split(list, ",") {
+		addr = strings.Trim(strings.TrimSpace(addr), "[]")
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("invalid address %q of resolve %q", addr, s)
+		}
+		addrs = append(addrs, addr)
+	}
+	if *r == nil {
+		*r = make(Resolves)
+	}
+	hostPort := net.JoinHostPort(strings.Trim(host, "[]"), port)
+	(*r)[hostPort] = append((*r)[hostPort], addrs...)
+	return nil
+}
+
+// Type description of argument
+func (r *Resolves) Type() string {
+	return "host:port:addr"
+}
+
+// ConnectTos replace host and port of requests by another host and port, an empty field matches or keeps any host or port
+type ConnectTos map[string]string
+
+func (c *ConnectTos) String() string {
+	terms := make([]string, 0, len(*c))
+	for from, to := range *c {
+		terms = append(terms, from+":"+to)
+	}
+	sort.Strings(terms)
+	return strings.Join(terms, " ")
+}
+
+// Set ConnectTos from argument, e.g. 'example.com:443:backend1:8443' or '::localhost:'
+func (c *ConnectTos) Set(s string) error {
+	host, rest, ok1 := cutField(s)
+	port, rest, ok2 := cutField(rest)
+	toHost, toPort, ok3 := cutField(rest)
+	if !ok1 || !ok2 || !ok3 || strings.Contains(toPort, ":") {
+		return fmt.Errorf("invalid connect-to %q, expected <host>:<port>:<connect-to-host>:<connect-to-port>", s)
+	}
+	if *c == nil {
+		*c = make(ConnectTos)
+	}
+	(*c)[bracket(strings.Trim(host, "[]"))+":"+port] = bracket(strings.Trim(toHost, "[]")) + ":" + toPort
+	return nil
+}
+
+// Type description of argument
+func (c *ConnectTos) Type() string {
+	return "host:port:host:port"
+}
+
+// target of the address, the first match of host and port, host only, port only and any address
+func (c ConnectTos) target(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || len(c) == 0 {
+		return addr
+	}
+	for _, from := range []string{bracket(host) + ":" + port, bracket(host) + ":", ":" + port, ":"} {
+		if to, ok := c[from]; ok {
+			toHost, toPort, _ := cutField(to)
+			if toHost == "" {
+				toHost = host
+			}
+			if toPort == "" {
+				toPort = port
+			}
+			return net.JoinHostPort(strings.Trim(toHost, "[]"), toPort)
+		}
+	}
+	return addr
+}
+
+// cutField up to the next colon, IPv6 addresses are enclosed in brackets
+func cutField(s string) (field, rest string, found bool) {
+	if strings.HasPrefix(s, "[") {
+		if end := strings.Index(s, "]"); end > 0 {
+			field, rest = s[:end+1], s[end+1:]
+			if rest == "" {
+				return field, "", false
+			}
+			return field, rest[1:], rest[0] == ':'
+		}
+	}
+	return strings.Cut(s, ":")
+}
+
+// bracket IPv6 addresses
+func bracket(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
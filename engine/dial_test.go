@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestResolvesSet(t *testing.T) {
+	var resolves Resolves
+	if err := resolves.Set("example.com:443:10.0.0.1,10.0.0.2"); err != nil {
+		t.Errorf("Resolves.Set has error %v", err)
+	}
+	if err := resolves.Set("[::1]:8080:[::2]"); err != nil {
+		t.Errorf("Resolves.Set with IPv6 has error %v", err)
+	}
+	if s := resolves.String(); s != "[::1]:8080:[::2] example.com:443:10.0.0.1,10.0.0.2" {
+		t.Errorf("Resolves.Set has invalid value %q", s)
+	}
+	for _, arg := range []string{"example.com:443", "example.com::10.0.0.1", "example.com:443:backend"} {
+		if err := resolves.Set(arg); err == nil {
+			t.Errorf("Resolves.Set(%q) must return an error!", arg)
+		}
+	}
+}
+
+func TestConnectTosTarget(t *testing.T) {
+	var connectTos ConnectTos
+	for _, arg := range []string{"example.com:443:backend1:8443", "example.org::backend2:", ":8080:[::1]:9090"} {
+		if err := connectTos.Set(arg); err != nil {
+			t.Errorf("ConnectTos.Set(%q) has error %v", arg, err)
+		}
+	}
+	for addr, expected := range map[string]string{
+		"example.com:443": "backend1:8443",
+		"example.com:80":  "example.com:80",
+		"example.org:443": "backend2:443",
+		"localhost:8080":  "[::1]:9090",
+	} {
+		if target := connectTos.target(addr); target != expected {
+			t.Errorf("Target of %q is %q, expected %q", addr, target, expected)
+		}
+	}
+	if err := connectTos.Set("example.com:443:backend"); err == nil {
+		t.Errorf("ConnectTos.Set without port must return an error!")
+	}
+}
+
+func TestExecResolveRoundRobin(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	var mu sync.Mutex
+	localAddrs := make(map[string]int)
+	listener, err := net.Listen("tcp4", ":0")
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		host, _, _ := net.SplitHostPort(r.Context().Value(http.LocalAddrContextKey).(net.Addr).String())
+		localAddrs[host]++
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	request.URL = "http://chail.test:" + port + "/"
+
+	var resolves Resolves
+	resolves.Set("chail.test:" + port + ":127.0.0.1,127.0.0.2")
+	options := Options{Request: request, NumClients: 1, NumRepeats: 4, NoKeepAlive: true, Resolve: resolves}
+	probe, _ := NewRunner(options).Exec(context.Background(), 1)
+	if probe.ErrRate > 0 || localAddrs["127.0.0.1"] != 2 || localAddrs["127.0.0.2"] != 2 {
+		t.Errorf("Requests must be spread across the resolved addresses: %s, %v", probe, localAddrs)
+	}
+}
+
+func TestDoConnectTo(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := startServer(t, "Content-Type", "application/xml")
+	defer server.Close()
+	var connectTos ConnectTos
+	connectTos.Set("chail.test:80:" + server.Listener.Addr().String())
+	request.URL = "http://chail.test/"
+
+	sample := NewRunner(Options{Request: request, ConnectTo: connectTos}).Do(context.Background())
+	if !sample.IsSuccessful() {
+		t.Errorf("Do with connect-to fails: %+v", sample)
+	}
+}
+
+func TestExecUnixSocket(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	socket := filepath.Join(t.TempDir(), "chail.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc((&TestHandler{t, "Content-Type", "application/xml"}).handle))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+	request.URL = "http://localhost/"
+
+	probe, _ := NewRunner(Options{Request: request, NumClients: 2, NumRepeats: 2, UnixSocket: socket}).Exec(context.Background(), 2)
+	if probe.ErrRate > 0 || probe.Connections < 1 || probe.NewConns+probe.ReusedConns != 4 {
+		t.Errorf("Exec with unix socket fails: %s with %d connections", probe, probe.Connections)
+	}
+}
+
+func TestExecLocalAddrs(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	var mu sync.Mutex
+	remoteAddrs := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		remoteAddrs[host]++
+	}))
+	defer server.Close()
+	request.URL = server.URL
+
+	localAddrs := []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.3")}
+	options := Options{Request: request, NumClients: 2, NumRepeats: 2, ClientPools: true, LocalAddrs: localAddrs, IPVersion: 4}
+	probe, _ := NewRunner(options).Exec(context.Background(), 2)
+	if probe.ErrRate > 0 || remoteAddrs["127.0.0.2"] != 2 || remoteAddrs["127.0.0.3"] != 2 {
+		t.Errorf("Clients must connect from their source addresses: %s, %v", probe, remoteAddrs)
+	}
+
+	// shared pools of the sources and the source of the family of the server
+	remoteAddrs = make(map[string]int)
+	localAddrs = []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("::1"), net.ParseIP("127.0.0.3")}
+	options = Options{Request: request, NumClients: 2, NumRepeats: 3, LocalAddrs: localAddrs}
+	probe, _ = NewRunner(options).Exec(context.Background(), 2)
+	if probe.ErrRate > 0 || remoteAddrs["127.0.0.2"] != 3 || remoteAddrs["127.0.0.3"] != 3 {
+		t.Errorf("Clients must reuse connections of their IPv4 source addresses only: %s, %v", probe, remoteAddrs)
+	}
+
+	options.LocalAddrs, options.IPVersion = nil, 6
+	sample := NewRunner(options).Do(context.Background())
+	if sample.IsSuccessful() {
+		t.Errorf("IPv4 server must not be reached with IPv6")
+	}
+}
+
+func TestNewSources(t *testing.T) {
+	sources := newSources([]net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1"), net.ParseIP("10.0.0.2")})
+	if len(sources) != 2 || !sources[1].ipv4.Equal(net.ParseIP("10.0.0.2")) || !sources[1].ipv6.Equal(net.ParseIP("fd00::1")) {
+		t.Errorf("Sources must pair the addresses of both families: %v", sources)
+	}
+	if !sources[0].dual() || !sources[0].localAddr("fd00::2").Equal(net.ParseIP("fd00::1")) || !sources[0].localAddr("10.0.1.1").Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Source must match the family of the remote address: %v", sources[0])
+	}
+	if sources := newSources(nil); len(sources) != 1 || sources[0].localAddr("10.0.1.1") != nil {
+		t.Errorf("Without addresses there must be a single source for any address: %v", sources)
+	}
+}
+
+func TestDoPortsExhausted(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := startServer(t, "Content-Type", "application/xml")
+	defer server.Close()
+
+	// TEST-NET-1 is not assigned to the host, so that no local port is available
+	sample := NewRunner(Options{Request: request, LocalAddrs: []net.IP{net.ParseIP("192.0.2.1")}}).Do(context.Background())
+	if sample.Failure != FailurePorts {
+		t.Errorf("Do from an unavailable source address has failure %q, expected %q: %v", sample.Failure, FailurePorts, sample.Err)
+	}
+}
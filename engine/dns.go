@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DNS strategies, every new connection resolves its host with the system settings if empty
+const (
+	DNSOnce  = "once"  // resolve every host once and reuse the addresses for the whole run
+	DNSEvery = "every" // resolve the host for every request, also on reused connections
+)
+
+// resolvedKey of the addresses resolved before a request in its context
+type resolvedKey struct{}
+
+// resolved addresses of a host
+type resolved struct {
+	host  string
+	addrs []net.IP
+}
+
+// resolver of the DNS server of the options, the default resolver if none
+func newResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer := &net.Dialer{Timeout: 5 * time.Second}
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// customDNS is true if the runner resolves the hosts itself instead of the dialer
+func (r *Runner) customDNS() bool {
+	return r.options.DNS != "" || r.options.DNSServer != ""
+}
+
+// resolve the host of the address to addresses with port, to be tried in turn,
+// the address is kept for IP addresses and without DNS options unless the lookup is required
+func (r *Runner) resolve(ctx context.Context, addr string, required bool) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || !r.customDNS() && !required || net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+	ips, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip.String(), port)
+	}
+	return addrs, nil
+}
+
+// lookup the addresses of the host, resolved before the request, cached or recorded as dns phase of the request
+func (r *Runner) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if res, ok := ctx.Value(resolvedKey{}).(resolved); ok && res.host == host {
+		return res.addrs, nil
+	}
+	if r.options.DNS != DNSOnce {
+		return r.lookupIP(ctx, host)
+	}
+
+	// concurrent requests wait for a single lookup of the host, failed lookups are repeated by the next request
+	r.dnsMu.Lock()
+	entry, ok := r.dnsCache[host]
+	if !ok {
+		entry = &dnsEntry{done: make(chan struct{})}
+		if r.dnsCache == nil {
+			r.dnsCache = make(map[string]*dnsEntry)
+		}
+		r.dnsCache[host] = entry
+	}
+	r.dnsMu.Unlock()
+	if ok {
+		select {
+		case <-entry.done:
+			return entry.addrs, entry.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	entry.addrs, entry.err = r.lookupIP(ctx, host)
+	if entry.err != nil {
+		r.dnsMu.Lock()
+		delete(r.dnsCache, host)
+		r.dnsMu.Unlock()
+	}
+	close(entry.done)
+	return entry.addrs, entry.err
+}
+
+// dnsEntry of a host with DNSOnce, done when the lookup is complete
+type dnsEntry struct {
+	done  chan struct{}
+	addrs []net.IP
+	err   error
+}
+
+// lookupIP of the host with the resolver, recorded as dns phase of the request
+func (r *Runner) lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	recorder, _ := ctx.Value(recorderKey{}).(*phaseRecorder)
+	if recorder != nil {
+		recorder.begin("dns")
+	}
+	addrs, err := r.resolver.LookupIP(ctx, r.network("ip"), host)
+	if recorder != nil {
+		recorder.end("dns")
+	}
+	if err == nil && len(addrs) == 0 {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, err
+}
+
+// resolveRequest resolves the host of the request in advance with DNSEvery, so that reused connections resolve as well
+func (r *Runner) resolveRequest(req *http.Request) (*http.Request, error) {
+	if r.options.DNS != DNSEvery || r.options.UnixSocket != "" {
+		return req, nil
+	}
+	if proxy, _ := r.proxy(req); proxy != nil {
+		// the proxy resolves the host
+		return req, nil
+	}
+	addr := r.options.ConnectTo.target(origin(req.URL))
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil || len(r.options.Resolve[net.JoinHostPort(host, port)]) > 0 {
+		return req, nil
+	}
+	addrs, err := r.lookup(req.Context(), host)
+	if err != nil {
+		return req, err
+	}
+	return req.WithContext(context.WithValue(req.Context(), resolvedKey{}, resolved{host, addrs})), nil
+}
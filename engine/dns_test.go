@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// startDNSServer answers A queries for chail.test after the delay with the addresses, 127.0.0.1 if none, and counts them,
+// other names do not exist
+func startDNSServer(t *testing.T, queries *atomic.Int64, delay time.Duration, addrs ...[4]byte) string {
+	if len(addrs) == 0 {
+		addrs = [][4]byte{{127, 0, 0, 1}}
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if query.Unpack(buf[:n]) != nil || len(query.Questions) != 1 {
+				continue
+			}
+			go func() {
+				time.Sleep(delay)
+				question := query.Questions[0]
+				reply := dnsmessage.Message{
+					Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true, RCode: dnsmessage.RCodeSuccess},
+					Questions: query.Questions,
+				}
+				if question.Name.String() != "chail.test." {
+					queries.Add(1)
+					reply.RCode = dnsmessage.RCodeNameError
+				} else if question.Type == dnsmessage.TypeA {
+					queries.Add(1)
+					for _, a := range addrs {
+						reply.Answers = append(reply.Answers, dnsmessage.Resource{
+							Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+							Body:   &dnsmessage.AResource{A: a},
+						})
+					}
+				}
+				packed, err := reply.Pack()
+				if err == nil {
+					conn.WriteTo(packed, addr)
+				}
+			}()
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestExecDNSOnce(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := startServer(t, "Content-Type", "application/xml")
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	request.URL = "http://chail.test:" + port + "/"
+	var queries atomic.Int64
+
+	options := Options{Request: request, NumClients: 2, NumRepeats: 3, NoKeepAlive: true, DNS: DNSOnce, DNSServer: startDNSServer(t, &queries, 0)}
+	probe, _ := NewRunner(options).Exec(context.Background(), 2)
+	if probe.ErrRate > 0 || queries.Load() != 1 || probe.DNSLookups != 1 || probe.AvgDNSNano <= 0 {
+		t.Errorf("Host must be resolved once: %s with %d queries and %d lookups", probe, queries.Load(), probe.DNSLookups)
+	}
+}
+
+func TestExecDNSEvery(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := startServer(t, "Content-Type", "application/xml")
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	request.URL = "http://chail.test:" + port + "/"
+	var queries atomic.Int64
+
+	options := Options{Request: request, NumClients: 1, NumRepeats: 4, DNS: DNSEvery, DNSServer: startDNSServer(t, &queries, 0)}
+	probe, _ := NewRunner(options).Exec(context.Background(), 1)
+	if probe.ErrRate > 0 || queries.Load() != 4 || probe.DNSLookups != 4 || probe.ReusedConns != 3 {
+		t.Errorf("Host must be resolved for every request on reused connections: %s with %d queries and %d lookups", probe, queries.Load(), probe.DNSLookups)
+	}
+}
+
+func TestDoDNSFailure(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	request.URL = "http://unknown.chail.test/"
+	var queries atomic.Int64
+
+	sample := NewRunner(Options{Request: request, DNSServer: startDNSServer(t, &queries, 0)}).Do(context.Background())
+	if sample.Failure != FailureDNS || sample.DNSTime <= 0 {
+		t.Errorf("Do of an unknown host has failure %q, expected %q: %v", sample.Failure, FailureDNS, sample.Err)
+	}
+}
+
+func TestExecDNSOnceFailure(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	request.URL = "http://unknown.chail.test/"
+	var queries atomic.Int64
+
+	// concurrent requests share the slow failed lookup, later requests repeat it
+	options := Options{Request: request, NumClients: 10, NumRepeats: 1, DNS: DNSOnce, DNSServer: startDNSServer(t, &queries, 200*time.Millisecond)}
+	r := NewRunner(options)
+	start := time.Now()
+	probe, _ := r.Exec(context.Background(), 10)
+	if elapsed := time.Since(start); probe.ErrRate != 1 || elapsed > time.Second {
+		t.Errorf("Failed lookup must be shared by the concurrent requests: %s in %v", probe, elapsed)
+	}
+	before := queries.Load()
+	if sample := r.Do(context.Background()); sample.Failure != FailureDNS || queries.Load() == before {
+		t.Errorf("Failed lookup must be repeated: %q after %d queries", sample.Failure, before)
+	}
+}
+
+func TestDoHTTP3DNSFallback(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	startHTTP3Server(t, "Content-Type", "application/xml")
+	_, port, _ := strings.Cut(request.URL, "127.0.0.1:")
+	request.URL = "https://chail.test:" + port + "/"
+	var queries atomic.Int64
+
+	// nothing listens on 127.0.0.2
+	server := startDNSServer(t, &queries, 0, [4]byte{127, 0, 0, 2}, [4]byte{127, 0, 0, 1})
+	sample := NewRunner(Options{Request: request, Timeout: 2 * time.Second, Protocol: HTTP3, DNSServer: server, TLS: TLSOptions{Insecure: true}}).Do(context.Background())
+	if !sample.IsSuccessful() {
+		t.Errorf("HTTP/3 must fall back to the next resolved address: %v", sample.Err)
+	}
+}
@@ -0,0 +1,339 @@
+package engine
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Failure categories of requests without response
+const (
+	FailureTimeout = "timeout"
+	FailureFetch   = "fetch"
+	FailureRead    = "read"
+	FailureCancel  = "cancel"
+	FailureProto   = "protocol"
+	FailurePorts   = "ports" // no free local port or source address
+	FailureDNS     = "dns"
+)
+
+// Sample of a single request
+type Sample struct {
+	ResponseCode                 int
+	TimeStartTransfer, TimeTotal time.Duration
+	Failure                      string
+	Err                          error
+	Start                        time.Time
+	Phases                       []Phase
+	ConnReused                   bool
+	Conn                         string        // local address of the connection
+	Proto                        string        // protocol of the response, e.g. HTTP/1.1 or HTTP/2.0
+	LimiterWait                  time.Duration // waiting time for the rate limits before the request
+	TLSVersion, TLSCipherSuite   uint16        // negotiated on the connection, 0 without TLS
+	TLSHandshake, TLSResumed     bool          // new handshake for the request and whether it resumed a session
+	TLSHandshakeTime             time.Duration // duration of the new handshake, not part of the request timings
+	ZeroRTT                      bool          // request sent as 0-RTT data on a resumed QUIC connection
+	DNSTime                      time.Duration // DNS lookups of the request, 0 without lookup
+}
+
+// TLS is the negotiated version and cipher suite, empty without TLS
+func (s Sample) TLS() string {
+	if s.TLSVersion == 0 {
+		return ""
+	}
+	return tls.VersionName(s.TLSVersion) + " " + tls.CipherSuiteName(s.TLSCipherSuite)
+}
+
+// IsSuccessful is true if and only if the response code is 2xx and the request did not fail otherwise
+func (s Sample) IsSuccessful() bool {
+	if s.Failure == "" && 199 < s.ResponseCode && s.ResponseCode < 300 {
+		return true
+	}
+	return false
+}
+
+// Category of the error, empty if the request is successful
+func (s Sample) Category() string {
+	switch {
+	case s.Failure != "":
+		return s.Failure
+	case s.IsSuccessful():
+		return ""
+	case s.ResponseCode >= 500:
+		return "server"
+	case s.ResponseCode >= 400:
+		return "client"
+	}
+	return "status"
+}
+
+// Probe is the result of a step with a fixed number of clients
+type Probe struct {
+	Clients, Requests                                   int
+	AvgTimeStartTransferNano, AvgTimeTotalNano, ErrRate float64
+	ResponseCodeCount                                   map[int]int
+	Duration                                            time.Duration
+	TimeTotalNanos                                      []int64        // sorted, successful requests only
+	Partial                                             bool           // step ended early by a limit of the run
+	LimiterWait                                         time.Duration  // sum of the waiting times for the rate limits
+	Stage                                               string         // stage of a load profile, empty for steps
+	RampRequests                                        int            // requests started before all clients were active or after the first one ended, not part of the statistics
+	TLSCount                                            map[string]int // requests by negotiated TLS version and cipher suite
+	Handshakes, ResumedHandshakes                       int
+	AvgHandshakeNano, AvgResumedHandshakeNano           float64        // full and resumed TLS handshakes
+	ProtoCount                                          map[string]int // requests by protocol of the response
+	Connections                                         int            // connections used by the requests
+	MaxStreams                                          int            // most concurrent requests on a single connection
+	ZeroRTT                                             int            // requests sent as 0-RTT data
+	NewConns, ReusedConns                               int            // requests on new and on reused connections
+	DNSLookups                                          int            // requests with DNS lookups
+	AvgDNSNano                                          float64        // DNS lookups of a request
+}
+
+// Percentile of total time in nanoseconds using the nearest-rank method
+func (p Probe) Percentile(q float64) float64 {
+	if len(p.TimeTotalNanos) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q/100*float64(len(p.TimeTotalNanos)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return float64(p.TimeTotalNanos[rank])
+}
+
+// RPS is the number of requests per second over the duration of the probe
+func (p Probe) RPS() float64 {
+	if p.Duration <= 0 {
+		return 0
+	}
+	return float64(p.Requests) / p.Duration.Seconds()
+}
+
+// HandshakeRates are the full and the resumed TLS handshakes per second over the duration of the probe
+func (p Probe) HandshakeRates() (full, resumed float64) {
+	if p.Duration <= 0 {
+		return 0, 0
+	}
+	return float64(p.Handshakes-p.ResumedHandshakes) / p.Duration.Seconds(), float64(p.ResumedHandshakes) / p.Duration.Seconds()
+}
+
+func (p Probe) String() string {
+	var s string
+	if p.Stage != "" {
+		s = "stage(" + p.Stage + ") "
+	}
+	s += fmt.Sprintf("%d: avg(starttransfer)=%.2fms, avg(total)=%.2fms, error=%.1f%%", p.Clients, p.AvgTimeStartTransferNano/1000000, p.AvgTimeTotalNano/1000000, p.ErrRate*100)
+	if p.RampRequests > 0 {
+		s += fmt.Sprintf(", ramp(%d)", p.RampRequests)
+	}
+	if p.Partial {
+		s += fmt.Sprintf(", partial(%d)", p.Requests+p.RampRequests)
+	}
+	return s
+}
+
+// Merge summarizes the probes of a whole run into a single probe
+func Merge(probes []Probe) Probe {
+	merged := Probe{ResponseCodeCount: make(map[int]int), TLSCount: make(map[string]int), ProtoCount: make(map[string]int)}
+	var sumTimeStartTransfer, sumTimeTotal, errorCount, sumHandshake, sumResumedHandshake, sumDNS float64
+	for _, p := range probes {
+		if p.Clients > merged.Clients {
+			merged.Clients = p.Clients
+		}
+		merged.Requests += p.Requests
+		merged.Duration += p.Duration
+		merged.LimiterWait += p.LimiterWait
+		merged.RampRequests += p.RampRequests
+		merged.Handshakes += p.Handshakes
+		merged.ResumedHandshakes += p.ResumedHandshakes
+		merged.ZeroRTT += p.ZeroRTT
+		merged.NewConns += p.NewConns
+		merged.ReusedConns += p.ReusedConns
+		sumHandshake += p.AvgHandshakeNano * float64(p.Handshakes-p.ResumedHandshakes)
+		sumResumedHandshake += p.AvgResumedHandshakeNano * float64(p.ResumedHandshakes)
+		merged.DNSLookups += p.DNSLookups
+		sumDNS += p.AvgDNSNano * float64(p.DNSLookups)
+		for name, count := range p.TLSCount {
+			merged.TLSCount[name] += count
+		}
+		for proto, count := range p.ProtoCount {
+			merged.ProtoCount[proto] += count
+		}
+		merged.Connections = max(merged.Connections, p.Connections)
+		merged.MaxStreams = max(merged.MaxStreams, p.MaxStreams)
+		merged.TimeTotalNanos = append(merged.TimeTotalNanos, p.TimeTotalNanos...)
+		if len(p.TimeTotalNanos) > 0 {
+			sumTimeStartTransfer += p.AvgTimeStartTransferNano * float64(len(p.TimeTotalNanos))
+			sumTimeTotal += p.AvgTimeTotalNano * float64(len(p.TimeTotalNanos))
+		}
+		errorCount += p.ErrRate * float64(p.Requests)
+		for code, count := range p.ResponseCodeCount {
+			merged.ResponseCodeCount[code] += count
+		}
+	}
+	sort.Slice(merged.TimeTotalNanos, func(i, j int) bool { return merged.TimeTotalNanos[i] < merged.TimeTotalNanos[j] })
+	if successCount := float64(len(merged.TimeTotalNanos)); successCount > 0 {
+		merged.AvgTimeStartTransferNano = sumTimeStartTransfer / successCount
+		merged.AvgTimeTotalNano = sumTimeTotal / successCount
+	}
+	if merged.Requests > 0 {
+		merged.ErrRate = errorCount / float64(merged.Requests)
+	}
+	if full := merged.Handshakes - merged.ResumedHandshakes; full > 0 {
+		merged.AvgHandshakeNano = sumHandshake / float64(full)
+	}
+	if merged.ResumedHandshakes > 0 {
+		merged.AvgResumedHandshakeNano = sumResumedHandshake / float64(merged.ResumedHandshakes)
+	}
+	if merged.DNSLookups > 0 {
+		merged.AvgDNSNano = sumDNS / float64(merged.DNSLookups)
+	}
+	return merged
+}
+
+// stats accumulates samples to a probe
+type stats struct {
+	sumTimeStartTransfer, sumTimeTotal, successCount, errorCount, requestCount int64
+	codeCount                                                                  map[int]int
+	timeTotalNanos                                                             []int64
+	limiterWait                                                                time.Duration
+	tlsCount                                                                   map[string]int
+	handshakes, resumedHandshakes, zeroRTT, newConns, reusedConns, dnsLookups  int
+	handshakeTime, resumedHandshakeTime, dnsTime                               time.Duration
+	protoCount                                                                 map[string]int
+	streams                                                                    map[string][]stream // requests by connection
+}
+
+// stream of a request on a connection
+type stream struct {
+	start, end time.Time
+}
+
+func newStats(capacity int) *stats {
+	return &stats{codeCount: make(map[int]int), tlsCount: make(map[string]int), protoCount: make(map[string]int),
+		streams: make(map[string][]stream), timeTotalNanos: make([]int64, 0, capacity)}
+}
+
+// maxConcurrent streams of all connections
+func maxConcurrent(streams map[string][]stream) int {
+	result := 0
+	for _, connStreams := range streams {
+		type event struct {
+			at    time.Time
+			delta int
+		}
+		events := make([]event, 0, 2*len(connStreams))
+		for _, s := range connStreams {
+			events = append(events, event{s.start, 1}, event{s.end, -1})
+		}
+		// ends before starts at the same time, successive requests are not concurrent
+		sort.Slice(events, func(i, j int) bool {
+			if events[i].at.Equal(events[j].at) {
+				return events[i].delta < events[j].delta
+			}
+			return events[i].at.Before(events[j].at)
+		})
+		active := 0
+		for _, e := range events {
+			active += e.delta
+			result = max(result, active)
+		}
+	}
+	return result
+}
+
+func (s *stats) add(sample Sample) {
+	s.requestCount++
+	s.limiterWait += sample.LimiterWait
+	if name := sample.TLS(); name != "" {
+		s.tlsCount[name]++
+	}
+	if sample.Proto != "" {
+		s.protoCount[sample.Proto]++
+	}
+	if sample.Conn != "" && sample.ConnReused {
+		s.reusedConns++
+	} else if sample.Conn != "" {
+		s.newConns++
+	}
+	if sample.Conn != "" {
+		end := sample.Start.Add(max(sample.TimeTotal, sample.TimeStartTransfer))
+		s.streams[sample.Conn] = append(s.streams[sample.Conn], stream{sample.Start, end})
+	}
+	if sample.ZeroRTT {
+		s.zeroRTT++
+	}
+	if sample.DNSTime > 0 {
+		s.dnsLookups++
+		s.dnsTime += sample.DNSTime
+	}
+	if sample.TLSHandshake {
+		s.handshakes++
+		if sample.TLSResumed {
+			s.resumedHandshakes++
+			s.resumedHandshakeTime += sample.TLSHandshakeTime
+		} else {
+			s.handshakeTime += sample.TLSHandshakeTime
+		}
+	}
+	if sample.IsSuccessful() {
+		s.successCount++
+		s.sumTimeStartTransfer += sample.TimeStartTransfer.Nanoseconds()
+		s.sumTimeTotal += sample.TimeTotal.Nanoseconds()
+		s.timeTotalNanos = append(s.timeTotalNanos, sample.TimeTotal.Nanoseconds())
+	} else {
+		s.errorCount++
+	}
+	s.codeCount[sample.ResponseCode]++
+}
+
+func (s *stats) probe(clients int, duration time.Duration) Probe {
+	sort.Slice(s.timeTotalNanos, func(i, j int) bool { return s.timeTotalNanos[i] < s.timeTotalNanos[j] })
+	probe := Probe{
+		Clients:                  clients,
+		Requests:                 int(s.requestCount),
+		AvgTimeStartTransferNano: float64(s.sumTimeStartTransfer) / float64(s.successCount),
+		AvgTimeTotalNano:         float64(s.sumTimeTotal) / float64(s.successCount),
+		ResponseCodeCount:        s.codeCount,
+		Duration:                 duration,
+		TimeTotalNanos:           s.timeTotalNanos,
+		LimiterWait:              s.limiterWait,
+		TLSCount:                 s.tlsCount,
+		Handshakes:               s.handshakes,
+		ResumedHandshakes:        s.resumedHandshakes,
+		ZeroRTT:                  s.zeroRTT,
+		NewConns:                 s.newConns,
+		ReusedConns:              s.reusedConns,
+		ProtoCount:               s.protoCount,
+		Connections:              len(s.streams),
+		MaxStreams:               maxConcurrent(s.streams),
+		DNSLookups:               s.dnsLookups,
+	}
+	if s.requestCount > 0 {
+		probe.ErrRate = float64(s.errorCount) / float64(s.requestCount)
+	}
+	if full := s.handshakes - s.resumedHandshakes; full > 0 {
+		probe.AvgHandshakeNano = float64(s.handshakeTime.Nanoseconds()) / float64(full)
+	}
+	if s.resumedHandshakes > 0 {
+		probe.AvgResumedHandshakeNano = float64(s.resumedHandshakeTime.Nanoseconds()) / float64(s.resumedHandshakes)
+	}
+	if s.dnsLookups > 0 {
+		probe.AvgDNSNano = float64(s.dnsTime.Nanoseconds()) / float64(s.dnsLookups)
+	}
+	return probe
+}
+
+// Result of a run
+type Result struct {
+	Probes       []Probe
+	LimitReached bool // run stopped by MaxDuration or MaxRequests
+}
+
+// Summary of all probes of the run
+func (r Result) Summary() Probe {
+	return Merge(r.Probes)
+}
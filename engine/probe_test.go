@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	probes := []Probe{
+		{Clients: 1, Requests: 2, Duration: time.Second, AvgTimeTotalNano: 10, TimeTotalNanos: []int64{10, 10}, ResponseCodeCount: map[int]int{200: 2}},
+		{Clients: 2, Requests: 4, Duration: time.Second, AvgTimeTotalNano: 40, ErrRate: 0.5, TimeTotalNanos: []int64{30, 50}, ResponseCodeCount: map[int]int{200: 2, 500: 2}},
+	}
+	run := Result{Probes: probes}.Summary()
+	if run.Clients != 2 || run.Requests != 6 || run.AvgTimeTotalNano != 25 || run.ResponseCodeCount[200] != 4 {
+		t.Errorf("Merge has invalid result: %+v", run)
+	}
+	if run.RPS() != 3 || run.Percentile(50) != 10 || run.Percentile(100) != 50 {
+		t.Errorf("Merge has invalid rps %f or percentiles %f, %f", run.RPS(), run.Percentile(50), run.Percentile(100))
+	}
+}
+
+func TestMergeHandshakes(t *testing.T) {
+	probes := []Probe{
+		{Requests: 2, Duration: time.Second, Handshakes: 2, ResumedHandshakes: 1, AvgHandshakeNano: 30, AvgResumedHandshakeNano: 10},
+		{Requests: 2, Duration: time.Second, Handshakes: 2, ResumedHandshakes: 0, AvgHandshakeNano: 60},
+	}
+	run := Merge(probes)
+	if run.Handshakes != 4 || run.ResumedHandshakes != 1 || run.AvgHandshakeNano != 50 || run.AvgResumedHandshakeNano != 10 {
+		t.Errorf("Merge has invalid handshakes: %+v", run)
+	}
+	if full, resumed := run.HandshakeRates(); full != 1.5 || resumed != 0.5 {
+		t.Errorf("Merge has invalid handshake rates %f, %f", full, resumed)
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	streams := map[string][]stream{
+		"127.0.0.1:40001": {{at(0), at(10)}, {at(10), at(20)}, {at(20), at(30)}},
+		"127.0.0.1:40002": {{at(0), at(10)}, {at(5), at(15)}, {at(8), at(12)}, {at(12), at(20)}},
+	}
+	if n := maxConcurrent(streams); n != 3 {
+		t.Errorf("maxConcurrent is %d, expected 3", n)
+	}
+}
+
+func TestSampleCategory(t *testing.T) {
+	for expected, sample := range map[string]Sample{
+		"":        {ResponseCode: 204},
+		"client":  {ResponseCode: 429},
+		"server":  {ResponseCode: 503},
+		"status":  {ResponseCode: 304},
+		"timeout": {Failure: FailureTimeout},
+	} {
+		if sample.Category() != expected {
+			t.Errorf("Category of %+v is %q, expected %q", sample, sample.Category(), expected)
+		}
+	}
+}
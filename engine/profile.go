@@ -0,0 +1,264 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ramps of a stage
+const (
+	Linear = "linear"
+	Step   = "step"
+)
+
+// stageTick is the interval of adjusting the clients and the rate of a stage
+const stageTick = 100 * time.Millisecond
+
+// Stage of a load profile, ramps from the target of the previous stage to its target
+type Stage struct {
+	Duration time.Duration
+	Clients  int     // target number of clients
+	Rate     float64 // target requests per second of Options.NumClients clients, replaces Clients if positive
+	Ramp     string  // linear or step, linear if empty
+}
+
+func (s Stage) String() string {
+	target := strconv.Itoa(s.Clients)
+	if s.Rate > 0 {
+		target = strconv.FormatFloat(s.Rate, 'f', -1, 64) + "rps"
+	}
+	if s.Ramp == Step {
+		return s.Duration.String() + ":" + target + ":" + Step
+	}
+	return s.Duration.String() + ":" + target
+}
+
+// at is the target of the stage after elapsed time, ramped from the target of the previous stage
+func (s Stage) at(from float64, elapsed time.Duration) float64 {
+	to := float64(s.Clients)
+	if s.Rate > 0 {
+		to = s.Rate
+	}
+	if s.Ramp == Step || elapsed >= s.Duration {
+		return to
+	}
+	return from + (to-from)*elapsed.Seconds()/s.Duration.Seconds()
+}
+
+// Stages of a load profile from arguments
+type Stages []Stage
+
+func (s *Stages) String() string {
+	terms := make([]string, len(*s))
+	for i, stage := range *s {
+		terms[i] = stage.String()
+	}
+	return strings.Join(terms, ",")
+}
+
+// Set Stage from argument, e.g. '30s:50', '1m:50:step' or '2m:200rps'
+func (s *Stages) Set(arg string) error {
+	terms := strings.Split(arg, ":")
+	if len(terms) < 2 || len(terms) > 3 {
+		return fmt.Errorf("invalid stage %q, expected <duration>:<clients>[:<ramp>] or <duration>:<rate>rps[:<ramp>]", arg)
+	}
+	var stage Stage
+	var err error
+	if stage.Duration, err = time.ParseDuration(terms[0]); err != nil || stage.Duration <= 0 {
+		return fmt.Errorf("invalid duration of stage %q", arg)
+	}
+	if rate, found := strings.CutSuffix(terms[1], "rps"); found {
+		if stage.Rate, err = strconv.ParseFloat(rate, 64); err != nil || stage.Rate <= 0 {
+			return fmt.Errorf("invalid rate of stage %q", arg)
+		}
+	} else if stage.Clients, err = strconv.Atoi(terms[1]); err != nil || stage.Clients < 0 {
+		return fmt.Errorf("invalid clients of stage %q", arg)
+	}
+	if len(terms) == 3 {
+		switch terms[2] {
+		case Linear, Step:
+			stage.Ramp = terms[2]
+		default:
+			return fmt.Errorf("invalid ramp of stage %q, expected %s or %s", arg, Linear, Step)
+		}
+	}
+	*s = append(*s, stage)
+	return nil
+}
+
+// Type description of argument
+func (s *Stages) Type() string {
+	return "stage"
+}
+
+// Presets of load profiles
+var Presets = []string{"spike", "soak", "stress", "breakpoint"}
+
+// Preset load profile with peak clients
+func Preset(name string, clients int) (Stages, error) {
+	low := max(clients/10, 1)
+	switch name {
+	case "spike":
+		return Stages{
+			{Duration: 30 * time.Second, Clients: low},
+			{Duration: time.Minute, Clients: low},
+			{Duration: time.Minute, Clients: clients, Ramp: Step},
+			{Duration: 2 * time.Minute, Clients: low, Ramp: Step},
+		}, nil
+	case "soak":
+		return Stages{
+			{Duration: 2 * time.Minute, Clients: clients},
+			{Duration: time.Hour, Clients: clients},
+			{Duration: 2 * time.Minute, Clients: 0},
+		}, nil
+	case "stress":
+		var stages Stages
+		for i := 1; i <= 4; i++ {
+			stages = append(stages,
+				Stage{Duration: 2 * time.Minute, Clients: clients * i / 4},
+				Stage{Duration: 5 * time.Minute, Clients: clients * i / 4})
+		}
+		return append(stages, Stage{Duration: 5 * time.Minute, Clients: 0}), nil
+	case "breakpoint":
+		return Stages{{Duration: 30 * time.Minute, Clients: clients}}, nil
+	}
+	return nil, fmt.Errorf("invalid profile %q, expected one of %s", name, strings.Join(Presets, ", "))
+}
+
+// runStages adjusts the number of clients or the rate on the fly and returns a probe per stage
+func (r *Runner) runStages(ctx context.Context) (Result, error) {
+	var result Result
+	samples := make(chan Sample, 1024)
+	var wg sync.WaitGroup
+	var stops []context.CancelFunc
+	scale := func(n int) {
+		for len(stops) < n {
+			stop, cancel := context.WithCancel(ctx)
+			stops = append(stops, cancel)
+			wg.Add(1)
+			go r.runClient(ctx, stop, &wg, samples)
+		}
+		for len(stops) > n {
+			stops[len(stops)-1]()
+			stops = stops[:len(stops)-1]
+		}
+	}
+	// finish stops all clients and collects their last samples
+	finish := func(collect func(Sample)) {
+		scale(0)
+		go func() {
+			wg.Wait()
+			close(samples)
+		}()
+		for sample := range samples {
+			collect(sample)
+		}
+	}
+
+	r.stageLimiter = newTokenBucket(0)
+	var fromClients, fromRate float64
+	for i, stage := range r.options.Stages {
+		if stage.Rate > 0 && fromRate == 0 {
+			fromRate = stage.Rate
+		}
+		adjust := func(elapsed time.Duration) {
+			if stage.Rate > 0 {
+				r.stageLimiter.setRate(math.Max(stage.at(fromRate, elapsed), 1))
+				scale(r.options.NumClients)
+			} else {
+				r.stageLimiter.setRate(0)
+				scale(int(math.Round(stage.at(fromClients, elapsed))))
+			}
+		}
+		adjust(0)
+		target := stage.Clients
+		if stage.Rate > 0 {
+			target = r.options.NumClients
+		}
+		for _, o := range r.options.Observers {
+			o.StepStarted(target)
+		}
+
+		stats := newStats(0)
+		collect := func(sample Sample) {
+			stats.add(sample)
+			for _, o := range r.options.Observers {
+				o.SampleCollected(sample)
+			}
+		}
+		start := time.Now()
+		ticker := time.NewTicker(stageTick)
+		timer := time.NewTimer(stage.Duration)
+		limitReached := false
+		for running := true; running; {
+			select {
+			case sample := <-samples:
+				collect(sample)
+			case <-ticker.C:
+				adjust(time.Since(start))
+				limitReached = r.limitReached()
+				running = !limitReached
+			case <-timer.C:
+				running = false
+			case <-ctx.Done():
+				running = false
+			}
+		}
+		ticker.Stop()
+		timer.Stop()
+		if err := ctx.Err(); err != nil {
+			finish(func(Sample) {})
+			return result, err
+		}
+		clients := target
+		if limitReached {
+			clients = len(stops)
+		}
+		if limitReached || i == len(r.options.Stages)-1 {
+			finish(collect)
+		}
+
+		probe := stats.probe(clients, time.Since(start))
+		probe.Stage = stage.String()
+		probe.Partial = limitReached
+		if r.options.Tracer != nil {
+			r.options.Tracer.flush()
+		}
+		for _, o := range r.options.Observers {
+			o.StepDone(probe)
+		}
+		result.Probes = append(result.Probes, probe)
+		if limitReached {
+			result.LimitReached = true
+			return result, nil
+		}
+
+		if stage.Rate > 0 {
+			fromRate = stage.Rate
+		} else {
+			fromRate = 0
+		}
+		fromClients = float64(clients)
+	}
+	return result, nil
+}
+
+// runClient sends requests until it is stopped
+func (r *Runner) runClient(ctx, stop context.Context, wg *sync.WaitGroup, samples chan<- Sample) {
+	defer wg.Done()
+
+	c := r.newClient()
+	defer c.close()
+	for {
+		sample, ok := c.next(ctx, stop)
+		if !ok {
+			return
+		}
+		samples <- sample
+	}
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStagesSet(t *testing.T) {
+	var stages Stages
+	for _, arg := range []string{"30s:50", "1m:50:step", "2m:200rps", "10s:0:linear"} {
+		if err := stages.Set(arg); err != nil {
+			t.Errorf("Stages.Set(%q) has error: %v", arg, err)
+		}
+	}
+	if stages.String() != "30s:50,1m0s:50:step,2m0s:200rps,10s:0" {
+		t.Errorf("Stages.Set has invalid value: %q", stages.String())
+	}
+	for _, arg := range []string{"30s", "x:50", "0s:50", "30s:-1", "30s:xrps", "30s:50:cubic", "30s:50:step:1"} {
+		if err := stages.Set(arg); err == nil {
+			t.Errorf("Stages.Set(%q) must return an error!", arg)
+		}
+	}
+}
+
+func TestStageAt(t *testing.T) {
+	linear := Stage{Duration: 10 * time.Second, Clients: 20}
+	step := Stage{Duration: 10 * time.Second, Clients: 20, Ramp: Step}
+	if v := linear.at(10, 5*time.Second); v != 15 {
+		t.Errorf("Linear stage from 10 to 20 must be 15 after half the duration, but was %f", v)
+	}
+	if v := step.at(10, 0); v != 20 {
+		t.Errorf("Step stage from 10 to 20 must be 20 at the start, but was %f", v)
+	}
+}
+
+func TestPreset(t *testing.T) {
+	for _, name := range Presets {
+		stages, err := Preset(name, 100)
+		if err != nil || len(stages) == 0 {
+			t.Errorf("Preset(%q) has error %v or no stages", name, err)
+		}
+	}
+	if _, err := Preset("chaos", 100); err == nil {
+		t.Errorf("Preset(%q) must return an error!", "chaos")
+	}
+}
+
+func TestRunStages(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	var stages Stages
+	stages.Set("200ms:2")
+	stages.Set("200ms:4:step")
+	stages.Set("200ms:0")
+	o := &countingObserver{}
+	result, err := NewRunner(Options{Request: request, Stages: stages, Observers: []Observer{o}}).Run(context.Background())
+	if err != nil || len(result.Probes) != 3 || o.probes != 3 {
+		t.Errorf("Run must return a probe per stage, but was %d probes and error %v", len(result.Probes), err)
+		return
+	}
+	for i, expected := range []int{2, 4, 0} {
+		if p := result.Probes[i]; p.Clients != expected || p.Stage != stages[i].String() || p.ErrRate != 0 {
+			t.Errorf("Stage %d has invalid probe: %s", i+1, p)
+		}
+	}
+	if result.Probes[1].Requests == 0 {
+		t.Errorf("Stage 2 has no requests")
+	}
+	if o.collected != result.Summary().Requests {
+		t.Errorf("Run collected %d samples, but probes have %d requests", o.collected, result.Summary().Requests)
+	}
+}
+
+func TestRunStagesWithRate(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	var stages Stages
+	stages.Set("500ms:40rps:step")
+	result, err := NewRunner(Options{Request: request, NumClients: 4, Stages: stages}).Run(context.Background())
+	if err != nil || len(result.Probes) != 1 {
+		t.Errorf("Run must return a probe per stage, but was %d probes and error %v", len(result.Probes), err)
+		return
+	}
+	if p := result.Probes[0]; p.Clients != 4 || p.Requests < 10 || p.Requests > 30 {
+		t.Errorf("Stage with 40 rps must have about 20 requests of 4 clients in 500ms, but was %d requests of %d clients", p.Requests, p.Clients)
+	}
+}
+
+func TestRunStagesMaxRequests(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	var stages Stages
+	stages.Set("10s:2:step")
+	result, err := NewRunner(Options{Request: request, Stages: stages, MaxRequests: 20}).Run(context.Background())
+	if err != nil || !result.LimitReached || len(result.Probes) != 1 || !result.Probes[0].Partial || result.Probes[0].Requests != 20 {
+		t.Errorf("Run must stop at request limit, but was %+v and error %v", result, err)
+	}
+}
+
+func TestRunStagesPoolSize(t *testing.T) {
+	setUp("GET", "", "")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { time.Sleep(50 * time.Millisecond) }))
+	defer server.Close()
+	request.URL = server.URL
+
+	// the shared pool allows a connection for every client of the stage, not of NumClients, idle ones beyond 2 are closed
+	var stages Stages
+	stages.Set("500ms:10:step")
+	result, err := NewRunner(Options{Request: request, NumClients: 1, Stages: stages}).Run(context.Background())
+	if err != nil || len(result.Probes) != 1 {
+		t.Errorf("Run must return a probe per stage, but was %d probes and error %v", len(result.Probes), err)
+		return
+	}
+	if p := result.Probes[0]; p.NewConns < 10 || p.RPS() < 100 {
+		t.Errorf("Stage with 10 clients must open at least 10 connections for about 200 rps, but was %d connections for %.1f rps", p.NewConns, p.RPS())
+	}
+}
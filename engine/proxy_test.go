@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNoProxy(t *testing.T) {
+	patterns := []string{"localhost", ".internal.example.com", "example.org", "10.0.0.0/8"}
+	for host, expected := range map[string]bool{
+		"localhost":                true,
+		"api.internal.example.com": true,
+		"example.org":              true,
+		"www.Example.org":          true,
+		"notexample.org":           false,
+		"10.1.2.3":                 true,
+		"192.168.1.1":              false,
+		"example.com":              false,
+	} {
+		if noProxy(host, patterns) != expected {
+			t.Errorf("noProxy(%q) must be %v", host, expected)
+		}
+	}
+	if !noProxy("example.com", []string{"*"}) {
+		t.Errorf("'*' must match all hosts")
+	}
+}
+
+func TestExecHTTPProxy(t *testing.T) {
+	setUp("POST", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+	proxy, requests := startProxyServer(t, "user:secret")
+
+	options := Options{Request: request, NumClients: 2, NumRepeats: 2, Proxy: proxy}
+	probe, err := NewRunner(options).Exec(context.Background(), 2)
+	if err != nil || probe.ErrRate > 0 || requests.Load() != 4 {
+		t.Errorf("Exec through proxy fails: %s, %v with %d proxy requests", probe, probe.ResponseCodeCount, requests.Load())
+	}
+
+	options.NoProxy = []string{"127.0.0.1"}
+	probe, _ = NewRunner(options).Exec(context.Background(), 2)
+	if probe.ErrRate > 0 || requests.Load() != 4 {
+		t.Errorf("Exec without proxy fails: %s with %d proxy requests", probe, requests.Load())
+	}
+
+	options.NoProxy, options.Proxy.User = nil, url.UserPassword("user", "wrong")
+	probe, _ = NewRunner(options).Exec(context.Background(), 2)
+	if probe.ResponseCodeCount[http.StatusProxyAuthRequired] != 4 {
+		t.Errorf("Proxy with wrong password must reject the requests: %v", probe.ResponseCodeCount)
+	}
+}
+
+func TestDoConnectProxy(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := startTLSServer(t, "Content-Type", "application/xml")
+	defer server.Close()
+	proxy, requests := startProxyServer(t, "")
+
+	sample := NewRunner(Options{Request: request, Proxy: proxy, TLS: TLSOptions{Insecure: true}}).Do(context.Background())
+	if !sample.IsSuccessful() || requests.Load() != 1 {
+		t.Errorf("Do through CONNECT tunnel fails: %+v with %d proxy requests", sample, requests.Load())
+	}
+	if phase := findPhase(sample.Phases, "proxy"); phase == nil || phase.End.Before(phase.Start) {
+		t.Errorf("Sample must have a proxy phase: %+v", sample.Phases)
+	}
+}
+
+func TestDoProxyWithConnectTo(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := startServer(t, "Content-Type", "application/xml")
+	defer server.Close()
+	proxy, requests := startProxyServer(t, "")
+
+	// connect-to applies to the host of the URL, not to the connection to the proxy
+	var connectTos ConnectTos
+	connectTos.Set("::127.0.0.1:1")
+	sample := NewRunner(Options{Request: request, Proxy: proxy, ConnectTo: connectTos}).Do(context.Background())
+	if !sample.IsSuccessful() || requests.Load() != 1 {
+		t.Errorf("Do through proxy with connect-to fails: %+v with %d proxy requests", sample, requests.Load())
+	}
+}
+
+func TestDoSOCKS5Proxy(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := startServer(t, "Content-Type", "application/xml")
+	defer server.Close()
+	proxy, connections := startSOCKS5Server(t, "user", "secret")
+
+	sample := NewRunner(Options{Request: request, Proxy: proxy}).Do(context.Background())
+	if !sample.IsSuccessful() || connections.Load() != 1 {
+		t.Errorf("Do through SOCKS5 proxy fails: %+v with %d proxy connections", sample, connections.Load())
+	}
+}
+
+func findPhase(phases []Phase, name string) *Phase {
+	for i := range phases {
+		if phases[i].Name == name {
+			return &phases[i]
+		}
+	}
+	return nil
+}
+
+// startProxyServer forwards requests and tunnels CONNECT requests, with basic authentication if auth is not empty
+func startProxyServer(t *testing.T, auth string) (*url.URL, *atomic.Int64) {
+	var requests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth != "" && r.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)) {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		requests.Add(1)
+		if r.Method == http.MethodConnect {
+			target, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			go pipe(target, buf)
+			pipe(conn, target)
+			return
+		}
+		out := r.Clone(r.Context())
+		out.RequestURI = ""
+		out.Header.Del("Proxy-Authorization")
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(ts.Close)
+	proxy, _ := url.Parse(ts.URL)
+	if user, password, found := strings.Cut(auth, ":"); found {
+		proxy.User = url.UserPassword(user, password)
+	}
+	return proxy, &requests
+}
+
+// startSOCKS5Server with username and password authentication
+func startSOCKS5Server(t *testing.T, user, password string) (*url.URL, *atomic.Int64) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var connections atomic.Int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				target, err := socks5Handshake(conn, user, password)
+				if err != nil {
+					return
+				}
+				connections.Add(1)
+				go pipe(target, conn)
+				pipe(conn, target)
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "socks5", Host: listener.Addr().String(), User: url.UserPassword(user, password)}, &connections
+}
+
+func socks5Handshake(conn net.Conn, user, password string) (net.Conn, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return nil, err
+	}
+	conn.Write([]byte{5, 2}) // username and password
+	credentials := make([]byte, 2)
+	io.ReadFull(conn, credentials)
+	u := make([]byte, credentials[1])
+	io.ReadFull(conn, u)
+	length := make([]byte, 1)
+	io.ReadFull(conn, length)
+	p := make([]byte, length[0])
+	io.ReadFull(conn, p)
+	if string(u) != user || string(p) != password {
+		conn.Write([]byte{1, 1})
+		return nil, io.EOF
+	}
+	conn.Write([]byte{1, 0})
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return nil, err
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		io.ReadFull(conn, length)
+		name := make([]byte, length[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return nil, io.EOF
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return target, nil
+}
+
+func pipe(dst io.WriteCloser, src io.Reader) {
+	defer dst.Close()
+	io.Copy(dst, src)
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxTokenWait is the longest sleep before a waiting client checks the bucket again, so that rate changes apply quickly
+const maxTokenWait = 100 * time.Millisecond
+
+// tokenBucket limits requests to rate per second with a burst of one request, safe for concurrent use
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // no limit if not positive
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: 1, last: time.Now()}
+}
+
+// setRate changes the rate for the following tokens
+func (b *tokenBucket) setRate(rate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.rate = rate
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.rate > 0 {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+	}
+	if b.tokens > 1 || b.rate <= 0 {
+		b.tokens = 1
+	}
+	b.last = now
+}
+
+// wait until the next token is available or the context is cancelled, returns the waiting time
+func (b *tokenBucket) wait(ctx context.Context) time.Duration {
+	start := time.Now()
+	waited := false
+	for ctx.Err() == nil {
+		b.mu.Lock()
+		now := time.Now()
+		b.refill(now)
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			break
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		sleep(ctx, min(delay, maxTokenWait))
+		waited = true
+	}
+	if !waited {
+		return 0
+	}
+	return time.Since(start)
+}
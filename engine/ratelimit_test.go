@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(100)
+	start := time.Now()
+	var waited time.Duration
+	for i := 0; i < 11; i++ {
+		waited += bucket.wait(context.Background())
+	}
+	if elapsed := time.Since(start); elapsed < 95*time.Millisecond || waited < 90*time.Millisecond {
+		t.Errorf("Token bucket with rate 100/s must take 100ms for 11 tokens, but took %v and waited %v", elapsed, waited)
+	}
+}
+
+func TestTokenBucketCancelled(t *testing.T) {
+	bucket := newTokenBucket(0.1)
+	bucket.wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if waited := bucket.wait(ctx); waited > time.Second {
+		t.Errorf("Token bucket must stop waiting if context is cancelled, but waited %v", waited)
+	}
+}
+
+func TestExecWithMaxRPS(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	probe, err := NewRunner(Options{Request: request, NumClients: 2, NumRepeats: 5, MaxRPS: 100}).Exec(context.Background(), 2)
+	if err != nil || probe.Requests != 10 || probe.Duration < 85*time.Millisecond || probe.LimiterWait == 0 {
+		t.Errorf("Exec with max rps 100 must take 90ms for 10 requests, but was %d requests in %v, waited %v", probe.Requests, probe.Duration, probe.LimiterWait)
+	}
+}
+
+func TestExecWithRatePerClient(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	probe, err := NewRunner(Options{Request: request, NumClients: 3, NumRepeats: 3, RatePerClient: 50}).Exec(context.Background(), 3)
+	if err != nil || probe.Requests != 9 || probe.Duration < 35*time.Millisecond {
+		t.Errorf("Exec with rate 50 per client must take 40ms for 3 requests per client, but was %d requests in %v", probe.Requests, probe.Duration)
+	}
+}
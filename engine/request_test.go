@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHeaderSet(t *testing.T) {
+	assertHeaderSet(t, "Content-Type: application/json", "Content-Type", []string{"application/json"})
+	assertHeaderSet(t, "content-type: application/json", "Content-Type", []string{"application/json"})
+	assertHeaderSet(t, "Content-Type: application/x-www-form-urlencoded", "Content-Type", []string{"application/x-www-form-urlencoded"})
+
+	assertHeaderSetWithMultipleLines(t,
+		[]string{"Content-Type: application/json", "Content-Type: application/xml"},
+		"Content-Type",
+		[]string{"application/json", "application/xml"})
+}
+
+func TestHeaderSetWithError(t *testing.T) {
+	line := "Content-Type=application/json"
+	header := make(Header)
+	err := header.Set(line)
+	if err == nil {
+		t.Errorf("Header.Set(%q) must return an error: %v", line, err)
+	}
+}
+
+func assertHeaderSet(t *testing.T, line, expectedKey string, expectedValues []string) {
+	assertHeaderSetWithMultipleLines(t, []string{line}, expectedKey, expectedValues)
+}
+
+func assertHeaderSetWithMultipleLines(t *testing.T, lines []string, expectedKey string, expectedValues []string) {
+	header := make(Header)
+	for _, line := range lines {
+		err := header.Set(line)
+		if err != nil {
+			t.Errorf("Header.Set(%q) has error: %v", line, err)
+			return
+		}
+	}
+	if len(header) != 1 {
+		t.Errorf("Header.Set(%q) failed, missing entry: %v", lines, len(header))
+		return
+	}
+
+	values := header[expectedKey]
+	if len(values) != len(expectedValues) {
+		t.Errorf("Header.Set(%q) failed, different values: %d <> %d", lines, len(values), len(expectedValues))
+		return
+	}
+
+	for _, expectedValue := range expectedValues {
+		if !contains(values, expectedValue) {
+			t.Errorf("Header.Set(%q) failed, missing value: %v", lines, expectedValue)
+		}
+	}
+}
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMethodSet(t *testing.T) {
+	assertMethod(t, "GET", http.MethodGet)
+	assertMethod(t, "POST", http.MethodPost)
+}
+
+func TestMethodSetWithError(t *testing.T) {
+	line := "PUT"
+	var method Method
+	err := method.Set(line)
+	if err == nil {
+		t.Errorf("Method.Set(%q) should return error!", line)
+	}
+}
+
+func assertMethod(t *testing.T, line, expectedMethod string) {
+	var method Method
+	err := method.Set(line)
+	if err != nil {
+		t.Errorf("Method.Set(%q) has error: %v", line, err)
+		return
+	}
+	if method.String() != expectedMethod {
+		t.Errorf("Method.Set(%q) results in an invalid value: %q, expected %q", line, method.String(), expectedMethod)
+	}
+}
+
+func TestDataSet(t *testing.T) {
+	assertData(t, "", "")
+	line := `{"info": "Updated"}`
+	assertData(t, line, line)
+	assertData(t, "@./request_test.json", line)
+}
+
+func TestDataSetWithError(t *testing.T) {
+	var data Data
+	line := "@not-exists.json"
+	err := data.Set(line)
+	if err == nil {
+		t.Errorf("Data.Set(%q) must return an error, because of missing file!", line)
+	}
+}
+
+func assertData(t *testing.T, line, expectedData string) {
+	var data Data
+	err := data.Set(line)
+	if err != nil {
+		t.Errorf("Data.Set(%q) has error: %v", line, err)
+		return
+	}
+	if data.String() != expectedData {
+		t.Errorf("Data.Set(%q) has invalid value: %q, expected %q", line, data.String(), expectedData)
+	}
+}
+
+func TestMultiPartFormDataSet(t *testing.T) {
+	assertValueOfMultiPartFormDataSet(t, "name", "", "")
+	assertValueOfMultiPartFormDataSet(t, "name={key='value'}", "name", "{key='value'}")
+	assertFileOfMultiPartFormDataSet(t, "name=@path/to/file", "name", "path/to/file", "")
+	assertFileOfMultiPartFormDataSet(t, "name=@path/to/file;type=application/json", "name", "path/to/file", "application/json")
+	assertFileOfMultiPartFormDataSet(t, "name=@path/to/file;type=application/json;more", "name", "path/to/file", "application/json;more")
+	assertFileOfMultiPartFormDataSet(t, "name=@path/to/file;invalid=application/json", "", "", "")
+}
+
+func assertValueOfMultiPartFormDataSet(t *testing.T, arg, expectedName, expectedValue string) {
+	m := NewMultiPartFormData()
+	err := m.Set(arg)
+	if err != nil && (expectedName != "" || expectedValue != "") {
+		t.Errorf("MultiPartFormData.Set(%q) has error: %v", arg, err)
+		return
+	}
+	if expectedValue != "" {
+		if len(m.Value[expectedName]) != 1 {
+			t.Errorf("MultiPartFormData.Set(%q) causes no unique value!", arg)
+		}
+		if m.Value[expectedName][0] != expectedValue {
+			t.Errorf("MultiPartFormData.Set(%q) has invalid value: %q, expected %q", arg, m.File[expectedName][0].Filename, expectedValue)
+		}
+		if !strings.HasPrefix(m.String(), "#Value=1") {
+			t.Errorf("MultiPartFormData.String() has invalid value: %q", m.String())
+		}
+	}
+
+}
+
+func assertFileOfMultiPartFormDataSet(t *testing.T, arg, expectedName, expectedFile, expectedOverrideType string) {
+	m := NewMultiPartFormData()
+	err := m.Set(arg)
+	if err != nil && (expectedName != "" || expectedFile != "" || expectedOverrideType != "") {
+		t.Errorf("MultiPartFormData.Set(%q) has error: %v", arg, err)
+		return
+	}
+	if expectedFile != "" {
+		if len(m.File[expectedName]) != 1 {
+			t.Errorf("MultiPartFormData.Set(%q) causes no unique value!", arg)
+		}
+		if m.File[expectedName][0].Filename != expectedFile {
+			t.Errorf("MultiPartFormData.Set(%q) has invalid value: %q, expected %q", arg, m.File[expectedName][0].Filename, expectedFile)
+		}
+		if len(m.File[expectedName][0].Header) != 2 {
+			t.Errorf("MultiPartFormData.Set(%q) has missing file type value!", arg)
+		}
+		if len(m.File[expectedName][0].Header["Content-Disposition"]) < 1 {
+			t.Errorf("MultiPartFormData.Set(%q) has missing content disposition", arg)
+		}
+		if expectedOverrideType != "" && expectedOverrideType != m.File[expectedName][0].Header["Content-Type"][0] {
+			t.Errorf("MultiPartFormData.Set(%q) has missing file type value: %q, expected %q", arg, m.File[expectedName][0].Header["Content-Type"], expectedOverrideType)
+		}
+		if !strings.HasSuffix(m.String(), "#File=1") {
+			t.Errorf("MultiPartFormData.String() has invalid value: %q", m.String())
+		}
+	}
+}
+
+func TestParseProperty(t *testing.T) {
+	assertParseProperty(t, "", "", "")
+	assertParseProperty(t, "a", "", "")
+	assertParseProperty(t, "a=", "a", "")
+	assertParseProperty(t, "a==", "a", "=")
+	assertParseProperty(t, "a=b", "a", "b")
+	assertParseProperty(t, "a=b=", "a", "b=")
+	assertParseProperty(t, " a =   ", "a", "")
+	assertParseProperty(t, " a = b ", "a", "b")
+}
+
+func assertParseProperty(t *testing.T, arg, expectedName, expectedValue string) {
+	name, value := parseProperty(arg)
+	if name != expectedName || value != expectedValue {
+		t.Errorf("Assertion for parseProperty(%q) fails, got name %q (expecting %q) and value %q (expecting %q)", arg, name, expectedValue, value, expectedValue)
+	}
+}
@@ -0,0 +1,565 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Observer is notified about steps and samples while a run is in progress
+type Observer interface {
+	// StepStarted before the clients of a step are started
+	StepStarted(clients int)
+	// RequestStarted is called concurrently by the clients
+	RequestStarted()
+	// RequestDone is called concurrently by the clients
+	RequestDone(sample Sample)
+	// SampleCollected is called sequentially by the collection loop of a step
+	SampleCollected(sample Sample)
+	// StepDone after all samples of a step are collected
+	StepDone(probe Probe)
+}
+
+// NopObserver ignores all notifications, embed it to implement only some methods of Observer
+type NopObserver struct{}
+
+// StepStarted is ignored
+func (NopObserver) StepStarted(clients int) {}
+
+// RequestStarted is ignored
+func (NopObserver) RequestStarted() {}
+
+// RequestDone is ignored
+func (NopObserver) RequestDone(sample Sample) {}
+
+// SampleCollected is ignored
+func (NopObserver) SampleCollected(sample Sample) {}
+
+// StepDone is ignored
+func (NopObserver) StepDone(probe Probe) {}
+
+// Options of a Runner
+type Options struct {
+	Request    Request // must be built
+	NumClients int     // steps from 1 to NumClients parallel clients
+	NumRepeats int     // successive requests of every client in a step
+	Stages     Stages  // load profile, replaces the steps if not empty
+	Timeout    time.Duration
+	TLS        TLSOptions
+	Handshakes bool   // fresh connection for every request and a session cache per client to benchmark the handshakes
+	Protocol   string // HTTP1, HTTP2, H2C or HTTP3, negotiated with ALPN if empty
+	Tracer     *Tracer
+	Observers  []Observer
+	Log        func(msg string) // verbose output of requests and responses, nil to disable
+
+	NoKeepAlive  bool          // new connection for every request
+	ClientPools  bool          // connection pool of its own for every client instead of one pool shared by all clients
+	MaxIdleConns int           // idle connections kept per pool, 2 if 0
+	MaxConns     int           // connections per pool, the most clients of the steps or stages if 0
+	IdleTimeout  time.Duration // idle connections are closed after the timeout, 90s if 0
+
+	Proxy   *url.URL // HTTP, HTTPS or SOCKS5 proxy with optional user and password, from the environment if nil
+	NoProxy []string // host names, domains, IP addresses or CIDRs reached without proxy
+
+	UnixSocket string     // path of the unix domain socket of all connections
+	Resolve    Resolves   // addresses of host and port instead of DNS, used round-robin
+	ConnectTo  ConnectTos // host and port connected instead of host and port of the URL
+	LocalAddrs []net.IP   // source addresses, rotated across the clients, of the family of the remote address
+	IPVersion  int        // 4 or 6 to force the address family, any if 0
+	DNS        string     // DNSOnce or DNSEvery, every new connection resolves with the system settings if empty
+	DNSServer  string     // address and port of the DNS server, the system settings if empty
+
+	SpawnRate   float64       // clients started per second in a step, 0 to start all at once
+	SpawnJitter time.Duration // maximum random delay of the start of a client in a step
+
+	ThinkTime ThinkTime     // pause between the iterations of a client
+	Pacing    time.Duration // fixed interval between the starts of the iterations of a client, replaces think time
+
+	RatePerClient float64 // requests per second of every client, 0 for no limit
+	MaxRPS        float64 // requests per second of all clients, 0 for no limit
+
+	MaxDuration time.Duration // limit of the whole run, 0 for no limit
+	MaxRequests int           // limit of requests of the whole run, 0 for no limit
+
+	WarmupDuration time.Duration // duration of Warmup
+	WarmupRequests int           // requests of Warmup, the first limit reached ends the warm-up
+}
+
+// Runner simulates parallel access to the URL of the request
+type Runner struct {
+	options  Options
+	clients  []*http.Client // one per client certificate and source, rotated across the clients without ClientPools
+	sources  []source       // source addresses, rotated across the clients
+	spawned  atomic.Int64
+	deadline time.Time
+	issued   atomic.Int64
+	resolved atomic.Int64 // connections to resolved addresses
+	limiter  *tokenBucket // global rate limit, nil for none
+	resolver *net.Resolver
+	dnsMu    sync.Mutex
+	dnsCache map[string]*dnsEntry // lookups of the hosts with DNSOnce
+
+	stageLimiter *tokenBucket // rate of the current stage, nil for none
+}
+
+// NewRunner with an HTTP client configured by the options
+func NewRunner(options Options) *Runner {
+	r := &Runner{options: options, resolver: newResolver(options.DNSServer)}
+	if options.MaxRPS > 0 {
+		r.limiter = newTokenBucket(options.MaxRPS)
+	}
+	r.initClient()
+	return r
+}
+
+func (r *Runner) initClient() {
+	r.sources = newSources(r.options.LocalAddrs)
+	for i := range max(len(r.options.TLS.Certificates), 1) {
+		for _, src := range r.sources {
+			r.clients = append(r.clients, r.newHTTPClient(i, src))
+		}
+	}
+}
+
+// newHTTPClient with a connection pool of its own, the client certificate at position i, if any, and the source addresses
+func (r *Runner) newHTTPClient(i int, src source) *http.Client {
+	// a TLS configuration of its own, so that every client has its own session cache
+	config := r.options.TLS.config()
+	if n := len(r.options.TLS.Certificates); n > 0 {
+		config.Certificates = []tls.Certificate{r.options.TLS.Certificates[i%n]}
+	}
+	return &http.Client{Transport: r.newTransport(config, src), Timeout: r.options.Timeout}
+}
+
+// Run the steps from 1 to NumClients clients or the stages until all are done, a limit is reached or the context is cancelled,
+// the result contains the completed steps in all cases
+func (r *Runner) Run(ctx context.Context) (Result, error) {
+	var result Result
+	if r.options.MaxDuration > 0 {
+		r.deadline = time.Now().Add(r.options.MaxDuration)
+	}
+	if len(r.options.Stages) > 0 {
+		return r.runStages(ctx)
+	}
+	for i := 1; i <= r.options.NumClients; i++ {
+		if r.limitReached() {
+			result.LimitReached = true
+			return result, nil
+		}
+		probe, err := r.Exec(ctx, i)
+		if err != nil {
+			return result, err
+		}
+		result.Probes = append(result.Probes, probe)
+		if probe.Partial {
+			result.LimitReached = true
+			return result, nil
+		}
+	}
+	return result, nil
+}
+
+// limitReached is true if the deadline of the run is over or all requests of the budget are issued
+func (r *Runner) limitReached() bool {
+	if !r.deadline.IsZero() && !time.Now().Before(r.deadline) {
+		return true
+	}
+	return r.options.MaxRequests > 0 && r.issued.Load() >= int64(r.options.MaxRequests)
+}
+
+// untilDeadline ends the context at the deadline of the run, so that no pause or wait overruns it
+func (r *Runner) untilDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, r.deadline)
+}
+
+// acquire a request of the budget, false if a limit is reached
+func (r *Runner) acquire() bool {
+	if !r.deadline.IsZero() && !time.Now().Before(r.deadline) {
+		return false
+	}
+	return r.options.MaxRequests <= 0 || r.issued.Add(1) <= int64(r.options.MaxRequests)
+}
+
+// Exec a single step with numClients parallel clients. If the context is cancelled,
+// the running requests are aborted and the incomplete probe is returned with the error of the context.
+// If a limit of the run is reached, the clients stop after their running requests and the probe is partial.
+func (r *Runner) Exec(ctx context.Context, numClients int) (Probe, error) {
+	if err := ctx.Err(); err != nil {
+		return Probe{Clients: numClients}, err
+	}
+
+	numRepeat := r.options.NumRepeats
+	chanClientSample := make(chan clientSamples, numClients)
+
+	for _, o := range r.options.Observers {
+		o.StepStarted(numClients)
+	}
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, delay := range r.spawnDelays(numClients) {
+		wg.Add(1)
+		go r.doClientRequests(ctx, delay, &wg, chanClientSample)
+	}
+
+	go func() {
+		wg.Wait()
+		close(chanClientSample)
+	}()
+
+	var clients []clientSamples
+	for clientSample := range chanClientSample {
+		clients = append(clients, clientSample)
+	}
+	end := time.Now()
+
+	// with spawn rate or jitter only the samples started with all clients active are part of the statistics,
+	// from the start of the last client to the end of the first one
+	active, done := start, end
+	if r.options.SpawnRate > 0 || r.options.SpawnJitter > 0 {
+		for _, c := range clients {
+			if c.start.After(active) {
+				active = c.start
+			}
+			if c.end.Before(done) {
+				done = c.end
+			}
+		}
+		if done.Before(active) {
+			done = active
+		}
+	}
+	stats := newStats(numClients * numRepeat)
+	var requestCount, rampCount int
+	for _, c := range clients {
+		for _, sample := range c.samples {
+			requestCount++
+			if sample.Start.Before(active) || sample.Start.After(done) {
+				rampCount++
+			} else {
+				stats.add(sample)
+			}
+			for _, o := range r.options.Observers {
+				o.SampleCollected(sample)
+			}
+		}
+	}
+	probe := stats.probe(numClients, done.Sub(active))
+	probe.RampRequests = rampCount
+	probe.Partial = requestCount < numClients*numRepeat
+	if err := ctx.Err(); err != nil {
+		return probe, err
+	}
+	if r.options.Tracer != nil {
+		r.options.Tracer.flush()
+	}
+	for _, o := range r.options.Observers {
+		o.StepDone(probe)
+	}
+	return probe, nil
+}
+
+// spawnDelays of the clients of a step by spawn rate and jitter
+func (r *Runner) spawnDelays(numClients int) []time.Duration {
+	delays := make([]time.Duration, numClients)
+	for i := range delays {
+		if r.options.SpawnRate > 0 {
+			delays[i] = time.Duration(float64(i) / r.options.SpawnRate * float64(time.Second))
+		}
+		if r.options.SpawnJitter > 0 {
+			delays[i] += rand.N(r.options.SpawnJitter)
+		}
+	}
+	return delays
+}
+
+// clientSamples of a client in a step with its start and end time
+type clientSamples struct {
+	start, end time.Time
+	samples    []Sample
+}
+
+func (r *Runner) doClientRequests(ctx context.Context, delay time.Duration, wg *sync.WaitGroup, chanClientSample chan<- clientSamples) {
+	defer wg.Done()
+
+	spawn, cancel := r.untilDeadline(ctx)
+	sleep(spawn, delay)
+	cancel()
+	c := r.newClient()
+	defer c.close()
+	clientSample := clientSamples{start: time.Now(), samples: make([]Sample, 0, r.options.NumRepeats)}
+	for i := 0; i < r.options.NumRepeats; i++ {
+		sample, ok := c.next(ctx, ctx)
+		if !ok {
+			break
+		}
+		clientSample.samples = append(clientSample.samples, sample)
+	}
+	clientSample.end = time.Now()
+	chanClientSample <- clientSample
+}
+
+// client sends successive requests with think time, pacing and rate limits between them
+type client struct {
+	r              *Runner
+	http           *http.Client
+	limiter        *tokenBucket
+	iterations     int
+	iterationStart time.Time
+}
+
+func (r *Runner) newClient() *client {
+	i := int(r.spawned.Add(1) - 1)
+	c := &client{r: r}
+	n := len(r.sources)
+	if r.ownPool() {
+		c.http = r.newHTTPClient(i, r.sources[i%n])
+	} else {
+		c.http = r.clients[i%(len(r.clients)/n)*n+i%n]
+	}
+	if r.options.RatePerClient > 0 {
+		c.limiter = newTokenBucket(r.options.RatePerClient)
+	}
+	return c
+}
+
+// next request of the client, false if the client is stopped, the context is cancelled or a limit of the run is reached.
+// The pauses end if stop is cancelled or at the deadline of the run, the request is aborted if ctx is cancelled.
+func (c *client) next(ctx, stop context.Context) (Sample, bool) {
+	r := c.r
+	stop, cancel := r.untilDeadline(stop)
+	defer cancel()
+	if c.iterations > 0 {
+		r.pause(stop, c.iterationStart)
+	}
+	c.iterations++
+	var limiterWait time.Duration
+	for _, limiter := range []*tokenBucket{c.limiter, r.limiter, r.stageLimiter} {
+		if limiter != nil {
+			limiterWait += limiter.wait(stop)
+		}
+	}
+	if stop.Err() != nil || ctx.Err() != nil || !r.acquire() {
+		return Sample{}, false
+	}
+	c.iterationStart = time.Now()
+	for _, o := range r.options.Observers {
+		o.RequestStarted()
+	}
+	sample := r.do(ctx, c.http, r.options.Tracer)
+	sample.LimiterWait = limiterWait
+	for _, o := range r.options.Observers {
+		o.RequestDone(sample)
+	}
+	return sample, true
+}
+
+// ownPool is true if every client has a pool and a TLS session cache of its own,
+// in handshake mode the first handshake of every client is a full one
+func (r *Runner) ownPool() bool {
+	return r.options.ClientPools || r.options.Handshakes
+}
+
+// close the connections of a client with a pool of its own
+func (c *client) close() {
+	if c.r.ownPool() {
+		c.http.CloseIdleConnections()
+	}
+}
+
+// pause between the iterations of a client, with pacing the iterations start at a fixed interval
+func (r *Runner) pause(ctx context.Context, iterationStart time.Time) {
+	if r.options.Pacing > 0 {
+		sleep(ctx, time.Until(iterationStart.Add(r.options.Pacing)))
+	} else {
+		sleep(ctx, r.options.ThinkTime.Next())
+	}
+}
+
+// Warmup sends requests with the clients of the first step or stage until WarmupDuration or WarmupRequests is reached.
+// The samples are neither traced nor passed to the observers, the probe summarizes the warm-up.
+// The clients of the run start again with the first client certificate and source, so that they use the warmed pools.
+func (r *Runner) Warmup(ctx context.Context) (Probe, error) {
+	var deadline time.Time
+	if r.options.WarmupDuration > 0 {
+		deadline = time.Now().Add(r.options.WarmupDuration)
+	}
+	numClients := r.initialClients()
+	stats := newStats(r.options.WarmupRequests)
+	var mu sync.Mutex
+	var issued atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range numClients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := r.newClient()
+			defer c.close()
+			for ctx.Err() == nil {
+				if r.options.WarmupRequests > 0 && issued.Add(1) > int64(r.options.WarmupRequests) {
+					break
+				}
+				if deadline.IsZero() && r.options.WarmupRequests <= 0 || !deadline.IsZero() && !time.Now().Before(deadline) {
+					break
+				}
+				sample := r.do(ctx, c.http, nil)
+				mu.Lock()
+				stats.add(sample)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	r.spawned.Store(0)
+	return stats.probe(numClients, time.Since(start)), ctx.Err()
+}
+
+// initialClients of the first step or stage
+func (r *Runner) initialClients() int {
+	if len(r.options.Stages) == 0 {
+		return 1
+	}
+	if stage := r.options.Stages[0]; stage.Rate <= 0 {
+		return max(stage.Clients, 1)
+	}
+	return max(r.options.NumClients, 1)
+}
+
+// maxClients of all steps and stages, the size of the connection pools
+func (r *Runner) maxClients() int {
+	n := max(r.options.NumClients, 1)
+	for _, stage := range r.options.Stages {
+		n = max(n, stage.Clients)
+	}
+	return n
+}
+
+// Do a single request with the first client certificate
+func (r *Runner) Do(ctx context.Context) Sample {
+	return r.do(ctx, r.clients[0], r.options.Tracer)
+}
+
+func (r *Runner) do(ctx context.Context, httpClient *http.Client, tracer *Tracer) (result Sample) {
+	request := r.options.Request
+
+	req, _ := http.NewRequestWithContext(ctx, request.Method.String(), request.URL, bytes.NewBuffer(request.Body))
+	for key, values := range request.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	recorder := newPhaseRecorder()
+	ctx = context.WithValue(context.WithValue(req.Context(), recorderKey{}, recorder), originKey{}, origin(req.URL))
+	req = req.WithContext(httptrace.WithClientTrace(ctx, recorder.clientTrace()))
+	if tracer != nil {
+		traceID, spanID := tracer.inject(req)
+		defer func() { tracer.record(traceID, spanID, req, &result) }()
+	}
+
+	r.logRequest(req)
+
+	start := time.Now()
+	result.Start = start
+	defer func() {
+		recorder.quicHandshakeDone()
+		recorder.mu.Lock()
+		result.Phases, result.ConnReused, result.Conn = recorder.phases, recorder.reused, recorder.conn
+		result.TLSHandshake, result.TLSResumed, result.TLSHandshakeTime = recorder.handshake, recorder.resumed, recorder.handshakeTime
+		result.ZeroRTT = recorder.zeroRTT
+		for _, phase := range recorder.phases {
+			if phase.Name == "dns" {
+				result.DNSTime += phase.End.Sub(phase.Start)
+			}
+		}
+		recorder.mu.Unlock()
+	}()
+	req, err := r.resolveRequest(req)
+	var resp *http.Response
+	if err == nil {
+		resp, err = httpClient.Do(r.early(req))
+	}
+
+	var dnsErr *net.DNSError
+	if errors.Is(err, context.Canceled) {
+		result.Failure, result.Err = FailureCancel, err
+		return result
+	} else if portsExhausted(err) {
+		result.Failure, result.Err = FailurePorts, err
+		return result
+	} else if errors.As(err, &dnsErr) {
+		result.Failure, result.Err = FailureDNS, err
+		return result
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		result.Failure, result.Err = FailureTimeout, err
+		return result
+	} else if err != nil {
+		result.Failure, result.Err = FailureFetch, err
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.ResponseCode, result.Proto = resp.StatusCode, resp.Proto
+	if resp.ProtoMajor != 2 && (r.options.Protocol == HTTP2 || r.options.Protocol == H2C) {
+		result.Failure, result.Err = FailureProto, fmt.Errorf("response with %s instead of HTTP/2", resp.Proto)
+		return result
+	}
+	if resp.TLS != nil {
+		result.TLSVersion, result.TLSCipherSuite = resp.TLS.Version, resp.TLS.CipherSuite
+	}
+	result.TimeStartTransfer = time.Since(start)
+
+	body, bodyErr := io.ReadAll(resp.Body)
+	if bodyErr != nil {
+		result.Failure, result.Err = FailureRead, bodyErr
+		return result
+	}
+
+	result.TimeTotal = time.Since(start)
+
+	r.logReponse(resp, body)
+
+	return result
+}
+
+func (r *Runner) logRequest(req *http.Request) {
+	r.logVerbose("> " + req.Method + " " + req.URL.RequestURI() + " " + req.Proto)
+	r.logVerbose("> Host: " + req.URL.Host)
+	r.logVerboseHeader("> ", req.Header)
+	r.logVerbose(">")
+}
+
+func (r *Runner) logReponse(resp *http.Response, body []byte) {
+	r.logVerbose("< " + resp.Proto + " " + resp.Status)
+	r.logVerboseHeader("< ", resp.Header)
+	r.logVerbose("<")
+	r.logVerbose(string(body))
+}
+
+func (r *Runner) logVerboseHeader(prefix string, header http.Header) {
+	for key, values := range header {
+		r.logVerbose(prefix + key + ": " + strings.Join(values, " "))
+	}
+}
+
+func (r *Runner) logVerbose(msg string) {
+	if r.options.Log != nil {
+		r.options.Log(msg)
+	}
+}
@@ -0,0 +1,442 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+var (
+	request Request
+)
+
+func TestRun(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	result, err := NewRunner(Options{Request: request, NumClients: 11, NumRepeats: 1}).Run(context.Background())
+	if err != nil || len(result.Probes) != 11 {
+		t.Errorf("Run fails, expected %d probes, but was %d probes and error %v", 11, len(result.Probes), err)
+	}
+}
+
+func TestRunWithErrors(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startResponseCodeServer(429)
+	defer server.Close()
+
+	result, _ := NewRunner(Options{Request: request, NumClients: 1, NumRepeats: 1}).Run(context.Background())
+	if len(result.Probes) != 1 || result.Probes[0].ErrRate != 1.0 || result.Probes[0].ResponseCodeCount[429] != 1 {
+		t.Errorf("Run fails, expected response code 429, but was %v", result.Probes)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := NewRunner(Options{Request: request, NumClients: 3, NumRepeats: 1}).Run(ctx)
+	if err != context.Canceled || len(result.Probes) != 0 {
+		t.Errorf("Run must stop if context is cancelled, but was %d probes and error %v", len(result.Probes), err)
+	}
+}
+
+func TestRunInterrupted(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var blocking atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if blocking.Load() {
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+	request.URL = server.URL
+
+	// the requests of the second step hang until the run is interrupted
+	o := &countingObserver{onProbe: func() {
+		blocking.Store(true)
+		time.AfterFunc(50*time.Millisecond, cancel)
+	}}
+	result, err := NewRunner(Options{Request: request, NumClients: 3, NumRepeats: 2, Observers: []Observer{o}}).Run(ctx)
+	if err != context.Canceled || len(result.Probes) != 1 || o.probes != 1 {
+		t.Errorf("Run must return the completed steps if interrupted, but was %d probes, %d notified and error %v", len(result.Probes), o.probes, err)
+	}
+}
+
+func TestRunMaxRequests(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	result, err := NewRunner(Options{Request: request, NumClients: 3, NumRepeats: 2, MaxRequests: 4}).Run(context.Background())
+	if err != nil || !result.LimitReached || len(result.Probes) != 2 {
+		t.Errorf("Run must stop at request limit, but was %d probes, limit reached %t and error %v", len(result.Probes), result.LimitReached, err)
+		return
+	}
+	if last := result.Probes[1]; !last.Partial || last.Requests != 2 || last.ErrRate != 0 {
+		t.Errorf("Last step must be partial with 2 requests, but was %+v", last)
+	}
+	if result.Probes[0].Partial {
+		t.Errorf("First step must be complete, but was %+v", result.Probes[0])
+	}
+}
+
+func TestRunMaxDuration(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	start := time.Now()
+	result, err := NewRunner(Options{Request: request, NumClients: 1000, NumRepeats: 1000, MaxDuration: 100 * time.Millisecond}).Run(context.Background())
+	if err != nil || !result.LimitReached {
+		t.Errorf("Run must stop at time limit, but was limit reached %t and error %v", result.LimitReached, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run must stop at time limit, but took %v", elapsed)
+	}
+}
+
+func TestRunMaxDurationWithPauses(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	for _, options := range []Options{
+		{ThinkTime: ThinkTime{Distribution: Constant, Mean: 2 * time.Second}},
+		{Pacing: 2 * time.Second},
+		{RatePerClient: 0.5},
+		{SpawnRate: 0.5},
+	} {
+		options.Request, options.NumClients, options.NumRepeats, options.MaxDuration = request, 2, 3, 300*time.Millisecond
+		start := time.Now()
+		result, err := NewRunner(options).Run(context.Background())
+		if elapsed := time.Since(start); err != nil || !result.LimitReached || elapsed > time.Second {
+			t.Errorf("Pauses and waits must end at the time limit, but run took %v with limit reached %t", elapsed, result.LimitReached)
+		}
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	o := &countingObserver{}
+	probe, err := NewRunner(Options{Request: request, NumClients: 2, NumRepeats: 1, WarmupRequests: 5, Observers: []Observer{o}}).Warmup(context.Background())
+	if err != nil || probe.Requests != 5 || probe.ErrRate != 0 {
+		t.Errorf("Warmup must send 5 requests, but was %+v and error %v", probe, err)
+	}
+	if o.started != 0 || o.collected != 0 {
+		t.Errorf("Warmup must not notify observers: %+v", o)
+	}
+}
+
+func TestWarmupStage(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	// every client of the first stage has a pool of its own source address
+	localAddrs := []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.3")}
+	stages := Stages{{Duration: time.Second, Clients: 2, Ramp: "step"}}
+	r := NewRunner(Options{Request: request, NumClients: 2, NumRepeats: 2, Stages: stages, LocalAddrs: localAddrs, WarmupRequests: 4})
+	probe, err := r.Warmup(context.Background())
+	if err != nil || probe.Clients != 2 || probe.Requests != 4 {
+		t.Errorf("Warmup must send 4 requests with the 2 clients of the first stage, but was %s and error %v", probe, err)
+	}
+	probe, _ = r.Exec(context.Background(), 2)
+	if probe.ErrRate > 0 || probe.NewConns != 0 || probe.ReusedConns != 4 {
+		t.Errorf("Clients must use the connections of the warm-up: %s with %d new connections", probe, probe.NewConns)
+	}
+}
+
+func TestWarmupDuration(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	probe, err := NewRunner(Options{Request: request, NumClients: 1, WarmupDuration: 50 * time.Millisecond}).Warmup(context.Background())
+	if err != nil || probe.Requests == 0 || probe.Duration < 50*time.Millisecond {
+		t.Errorf("Warmup must send requests for 50ms, but was %d requests in %v and error %v", probe.Requests, probe.Duration, err)
+	}
+}
+
+func TestDoCancelled(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sample := NewRunner(Options{Request: request, NumClients: 1}).Do(ctx)
+	if sample.Failure != FailureCancel {
+		t.Errorf("Do with cancelled context has failure %q, expected %q", sample.Failure, FailureCancel)
+	}
+}
+
+func TestExec(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	probe, err := NewRunner(Options{Request: request, NumClients: 2, NumRepeats: 2}).Exec(context.Background(), 2)
+	if err != nil || probe.Clients != 2 || probe.ErrRate > 0.0 {
+		t.Errorf("Exec fails, expected %d clients %f error rate, but was %d clients and %f error rate!", 2, 0.0, probe.Clients, probe.ErrRate)
+	}
+}
+
+func TestExecWithSpawnRate(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	// the clients start at 0ms, 50ms and 100ms and end 200ms later, so that all are active from 100ms to 200ms
+	o := &countingObserver{}
+	probe, err := NewRunner(Options{Request: request, NumClients: 3, NumRepeats: 20, SpawnRate: 20, Pacing: 10 * time.Millisecond, Observers: []Observer{o}}).Exec(context.Background(), 3)
+	if err != nil || probe.Partial || probe.Requests+probe.RampRequests != 60 || o.collected != 60 {
+		t.Errorf("Exec with spawn rate must send 60 requests, but was %s and error %v", probe, err)
+	}
+	if probe.RampRequests < 25 || probe.Duration < 50*time.Millisecond || probe.Duration > 150*time.Millisecond {
+		t.Errorf("Exec with spawn rate 20/s must exclude the first and the last 100ms, but was %d ramp requests and duration %v", probe.RampRequests, probe.Duration)
+	}
+}
+
+func TestSpawnDelays(t *testing.T) {
+	delays := NewRunner(Options{SpawnRate: 10, SpawnJitter: 50 * time.Millisecond}).spawnDelays(3)
+	for i, d := range delays {
+		if min := time.Duration(i) * 100 * time.Millisecond; d < min || d >= min+50*time.Millisecond {
+			t.Errorf("Spawn delay of client %d is %v, expected %v plus jitter up to 50ms", i, d, min)
+		}
+	}
+}
+
+func TestExecNotifiesObservers(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	o := &countingObserver{}
+	NewRunner(Options{Request: request, NumClients: 2, NumRepeats: 3, Observers: []Observer{o}}).Exec(context.Background(), 2)
+	if o.steps != 1 || o.started != 6 || o.done != 6 || o.collected != 6 || o.probes != 1 {
+		t.Errorf("Exec notifies observers with invalid counts: %+v", o)
+	}
+}
+
+type countingObserver struct {
+	sync.Mutex
+	steps, started, done, collected, probes int
+	onProbe                                 func()
+}
+
+func (o *countingObserver) StepStarted(clients int)       { o.steps++ }
+func (o *countingObserver) RequestStarted()               { o.Lock(); o.started++; o.Unlock() }
+func (o *countingObserver) RequestDone(sample Sample)     { o.Lock(); o.done++; o.Unlock() }
+func (o *countingObserver) SampleCollected(sample Sample) { o.collected++ }
+func (o *countingObserver) StepDone(probe Probe) {
+	o.probes++
+	if o.onProbe != nil {
+		o.onProbe()
+	}
+}
+
+func TestDoTLS(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+
+	server := startTLSServer(t, "Content-Type", "application/xml")
+	defer server.Close()
+
+	if !strings.HasPrefix(server.URL, "https:") {
+		t.Errorf("Expected protocol %q, but URL is %q", "https:", server.URL)
+	}
+
+	certContent := server.TLS.Certificates[0].Certificate[0]
+	pemContent := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certContent})
+	runner := NewRunner(Options{Request: request, NumClients: 1, Timeout: time.Duration(1 * time.Second), TLS: TLSOptions{CaCert: pemContent}})
+
+	sample := runner.Do(context.Background())
+	if !sample.IsSuccessful() {
+		t.Errorf("Do fails: %s %s", request.Method.String(), server.URL)
+	}
+}
+
+func TestDoInsecureTLS(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+
+	server := startTLSServer(t, "Content-Type", "application/xml")
+	defer server.Close()
+
+	if !strings.HasPrefix(server.URL, "https:") {
+		t.Errorf("Expected protocol %q, but URL is %q", "https:", server.URL)
+	}
+
+	runner := NewRunner(Options{Request: request, NumClients: 1, Timeout: time.Duration(1 * time.Second), TLS: TLSOptions{Insecure: true}})
+
+	sample := runner.Do(context.Background())
+	if !sample.IsSuccessful() {
+		t.Errorf("Do fails: %s %s", request.Method.String(), server.URL)
+	}
+}
+
+func TestExecMutualTLS(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	var mu sync.Mutex
+	identities := make(map[string]int)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		identities[r.TLS.PeerCertificates[0].Subject.CommonName]++
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	request.URL = server.URL
+
+	certificates := []tls.Certificate{loadTestCertificate(t, "client-a"), loadTestCertificate(t, "client-b")}
+	runner := NewRunner(Options{Request: request, NumClients: 4, NumRepeats: 2, TLS: TLSOptions{Insecure: true, Certificates: certificates}})
+	probe, err := runner.Exec(context.Background(), 4)
+	if err != nil || probe.ErrRate > 0 {
+		t.Errorf("Exec with client certificates fails: %s", probe)
+	}
+	if identities["client-a"] != 4 || identities["client-b"] != 4 {
+		t.Errorf("Client certificates must be rotated across clients, but server has seen %v", identities)
+	}
+}
+
+// loadTestCertificate of the self-signed fixture with certificate and key, shared with the tests of the main package
+func loadTestCertificate(t *testing.T, name string) tls.Certificate {
+	file := filepath.Join("..", "testdata", name+".pem")
+	certificate, err := tls.LoadX509KeyPair(file, file)
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	return certificate
+}
+
+func TestDoGET(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := startServer(t, "Content-Type", "application/xml")
+	defer server.Close()
+
+	sample := NewRunner(Options{Request: request}).Do(context.Background())
+	if !sample.IsSuccessful() {
+		t.Errorf("Do fails: %s %s", request.Method.String(), server.URL)
+	}
+}
+
+func TestDoGETButClientError(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := startResponseCodeServer(400)
+	defer server.Close()
+
+	sample := NewRunner(Options{Request: request}).Do(context.Background())
+	if sample.IsSuccessful() || sample.Category() != "client" {
+		t.Errorf("Do should fail with response code 400: %d %d", 400, sample.ResponseCode)
+	}
+}
+
+func TestDoPOST(t *testing.T) {
+	setUp("POST", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	sample := NewRunner(Options{Request: request}).Do(context.Background())
+	if !sample.IsSuccessful() {
+		t.Errorf("Do fails: %s %s", request.Method.String(), server.URL)
+	}
+}
+
+func setUp(method, headerLine, data string) {
+	request.Method.Set(method)
+	request.Header = make(Header)
+	request.Header.Set(headerLine)
+	request.Data.Set(data)
+	request.Build()
+}
+
+func startResponseCodeServer(responseCode int) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(responseCode) }))
+	request.URL = ts.URL
+	return ts
+}
+
+func startServer(t *testing.T, key, value string) *httptest.Server {
+	th := TestHandler{t, key, value}
+	ts := httptest.NewServer(http.HandlerFunc(th.handle))
+	request.URL = ts.URL
+	return ts
+}
+
+func startTLSServer(t *testing.T, key, value string) *httptest.Server {
+	th := TestHandler{t, key, value}
+	ts := httptest.NewTLSServer(http.HandlerFunc(th.handle))
+	request.URL = ts.URL
+	return ts
+}
+
+func startHTTP3Server(t *testing.T, key, value string) *http3.Server {
+	th := TestHandler{t, key, value}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{loadTestCertificate(t, "localhost")}}
+	server := &http3.Server{Handler: http.HandlerFunc(th.handle), TLSConfig: http3.ConfigureTLSConfig(config)}
+	go server.Serve(conn)
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+	})
+	request.URL = "https://" + conn.LocalAddr().String()
+	return server
+}
+
+type TestHandler struct {
+	*testing.T
+	key, value string
+}
+
+func (t *TestHandler) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != request.Method.String() {
+		t.Errorf("Request has method %s, but expected %s", r.Method, request.Method.String())
+		http.Error(w, "invalid method", http.StatusBadRequest)
+		return
+	}
+	if r.Header[t.key][0] != t.value {
+		t.Errorf("Expected header %q with value %q but got %v!", t.key, t.value, r.Header)
+		http.Error(w, "invalid header", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Errorf("Error reading request body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	if string(body) != request.Data.String() {
+		t.Errorf("Expected body %s, but was %s", string(body), request.Data.String())
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+}
+func (t *TestHandler) handleError(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "custom error", http.StatusBadRequest)
+}
@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// Distributions of think time
+const (
+	Constant    = "constant"
+	Uniform     = "uniform"
+	Exponential = "exponential"
+	Normal      = "normal"
+)
+
+// ThinkTime between the iterations of a client from arguments
+type ThinkTime struct {
+	Distribution string        // empty for no think time
+	Mean, Spread time.Duration // spread is the half range of uniform and the standard deviation of normal
+}
+
+func (t *ThinkTime) String() string {
+	switch t.Distribution {
+	case Constant:
+		return t.Mean.String()
+	case Uniform:
+		return (t.Mean - t.Spread).String() + "-" + (t.Mean + t.Spread).String()
+	case Exponential:
+		return "exp:" + t.Mean.String()
+	case Normal:
+		return "normal:" + t.Mean.String() + "," + t.Spread.String()
+	}
+	return ""
+}
+
+// Set ThinkTime from argument, e.g. '1s', '500ms-2s', 'exp:1s' or 'normal:1s,200ms'
+func (t *ThinkTime) Set(s string) error {
+	var err error
+	switch {
+	case strings.HasPrefix(s, "exp:"):
+		t.Distribution = Exponential
+		t.Mean, err = parseThinkDuration(strings.TrimPrefix(s, "exp:"))
+		t.Spread = 0
+	case strings.HasPrefix(s, "normal:"):
+		mean, stdDev, found := strings.Cut(strings.TrimPrefix(s, "normal:"), ",")
+		if !found {
+			return fmt.Errorf("invalid think time %q, expected normal:<mean>,<standard deviation>", s)
+		}
+		t.Distribution = Normal
+		if t.Mean, err = parseThinkDuration(mean); err == nil {
+			t.Spread, err = parseThinkDuration(stdDev)
+		}
+	case strings.Contains(s, "-"):
+		first, second, _ := strings.Cut(s, "-")
+		var min, max time.Duration
+		if min, err = parseThinkDuration(first); err == nil {
+			max, err = parseThinkDuration(second)
+		}
+		if err == nil && max < min {
+			return fmt.Errorf("invalid think time %q, minimum is greater than maximum", s)
+		}
+		t.Distribution = Uniform
+		t.Mean, t.Spread = (min+max)/2, (max-min)/2
+	default:
+		t.Distribution = Constant
+		t.Mean, err = parseThinkDuration(s)
+		t.Spread = 0
+	}
+	if err != nil {
+		return fmt.Errorf("invalid think time %q: %v", s, err)
+	}
+	return nil
+}
+
+// Type description of argument
+func (t *ThinkTime) Type() string {
+	return "distribution"
+}
+
+func parseThinkDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		return 0, fmt.Errorf("negative duration %s", s)
+	}
+	return d, err
+}
+
+// Next random think time of the distribution
+func (t ThinkTime) Next() time.Duration {
+	var d time.Duration
+	switch t.Distribution {
+	case Constant:
+		d = t.Mean
+	case Uniform:
+		d = t.Mean - t.Spread
+		if t.Spread > 0 {
+			d += rand.N(2*t.Spread + 1)
+		}
+	case Exponential:
+		d = time.Duration(rand.ExpFloat64() * float64(t.Mean))
+	case Normal:
+		d = t.Mean + time.Duration(rand.NormFloat64()*float64(t.Spread))
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// sleep until d is over or the context is cancelled
+func sleep(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
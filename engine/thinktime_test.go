@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestThinkTimeSet(t *testing.T) {
+	tests := []struct {
+		arg, expected string
+		mean, spread  time.Duration
+	}{
+		{"1s", "1s", time.Second, 0},
+		{"500ms-2s", "500ms-2s", 1250 * time.Millisecond, 750 * time.Millisecond},
+		{"exp:1s", "exp:1s", time.Second, 0},
+		{"normal:1s,200ms", "normal:1s,200ms", time.Second, 200 * time.Millisecond},
+	}
+	for _, test := range tests {
+		var thinkTime ThinkTime
+		if err := thinkTime.Set(test.arg); err != nil {
+			t.Errorf("ThinkTime.Set(%q) has error: %v", test.arg, err)
+			continue
+		}
+		if thinkTime.String() != test.expected || thinkTime.Mean != test.mean || thinkTime.Spread != test.spread {
+			t.Errorf("ThinkTime.Set(%q) has invalid value: %q %+v", test.arg, thinkTime.String(), thinkTime)
+		}
+	}
+	for _, arg := range []string{"", "1x", "-1s", "2s-1s", "exp:", "normal:1s"} {
+		var thinkTime ThinkTime
+		if err := thinkTime.Set(arg); err == nil {
+			t.Errorf("ThinkTime.Set(%q) must return an error!", arg)
+		}
+	}
+}
+
+func TestThinkTimeNext(t *testing.T) {
+	var none ThinkTime
+	if d := none.Next(); d != 0 {
+		t.Errorf("ThinkTime without distribution must be 0, but was %v", d)
+	}
+	uniform := ThinkTime{Distribution: Uniform, Mean: 1250 * time.Millisecond, Spread: 750 * time.Millisecond}
+	normal := ThinkTime{Distribution: Normal, Mean: 10 * time.Millisecond, Spread: time.Second}
+	exponential := ThinkTime{Distribution: Exponential, Mean: time.Second}
+	var sum time.Duration
+	for i := 0; i < 1000; i++ {
+		if d := uniform.Next(); d < 500*time.Millisecond || d > 2*time.Second {
+			t.Errorf("Uniform think time %v is out of range 500ms-2s", d)
+		}
+		if d := normal.Next(); d < 0 {
+			t.Errorf("Normal think time %v must not be negative", d)
+		}
+		sum += exponential.Next()
+	}
+	if mean := sum / 1000; mean < 800*time.Millisecond || mean > 1200*time.Millisecond {
+		t.Errorf("Exponential think time has mean %v, expected about 1s", mean)
+	}
+}
+
+func TestExecWithPacing(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	probe, err := NewRunner(Options{Request: request, NumClients: 2, NumRepeats: 3, Pacing: 30 * time.Millisecond}).Exec(context.Background(), 2)
+	if err != nil || probe.Requests != 6 || probe.Duration < 60*time.Millisecond {
+		t.Errorf("Exec with pacing 30ms must take at least 60ms for 3 iterations, but was %d requests in %v", probe.Requests, probe.Duration)
+	}
+	if probe.AvgTimeTotalNano > float64(30*time.Millisecond) {
+		t.Errorf("Pacing must not be part of the total time, but was avg %.2fms", probe.AvgTimeTotalNano/1e6)
+	}
+}
+
+func TestExecWithThinkTime(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	server := startServer(t, "Content-Type", "application/json")
+	defer server.Close()
+
+	thinkTime := ThinkTime{Distribution: Constant, Mean: 20 * time.Millisecond}
+	probe, err := NewRunner(Options{Request: request, NumClients: 1, NumRepeats: 3, ThinkTime: thinkTime}).Exec(context.Background(), 1)
+	if err != nil || probe.Requests != 3 || probe.Duration < 40*time.Millisecond {
+		t.Errorf("Exec with think time 20ms must take at least 40ms for 3 iterations, but was %d requests in %v", probe.Requests, probe.Duration)
+	}
+}
@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// TLSOptions of the client connections
+type TLSOptions struct {
+	Insecure          bool              // skip verification of the server certificate
+	CaCert            []byte            // PEM, trusted in addition to the system roots
+	Certificates      []tls.Certificate // client certificates, rotated across the clients
+	MinVersion        TLSVersion
+	MaxVersion        TLSVersion
+	CipherSuites      CipherSuites // TLS 1.0-1.2 only, TLS 1.3 suites are not configurable
+	CurvePreferences  Curves
+	ALPN              []string
+	ServerName        string // SNI and name of the verified server certificate, host of the URL if empty
+	SessionResumption bool   // resume TLS sessions on new connections
+}
+
+// config builds the TLS configuration of all client connections
+func (o TLSOptions) config() *tls.Config {
+	config := &tls.Config{
+		InsecureSkipVerify: o.Insecure,
+		MinVersion:         uint16(o.MinVersion),
+		MaxVersion:         uint16(o.MaxVersion),
+		CipherSuites:       o.CipherSuites,
+		CurvePreferences:   o.CurvePreferences,
+		NextProtos:         o.ALPN,
+		ServerName:         o.ServerName,
+	}
+	if o.CaCert != nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AppendCertsFromPEM(o.CaCert)
+		config.RootCAs = pool
+	}
+	if o.SessionResumption {
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	} else {
+		config.SessionTicketsDisabled = true
+	}
+	return config
+}
+
+// TLSVersion from argument
+type TLSVersion uint16
+
+var tlsVersions = map[string]uint16{"1.0": tls.VersionTLS10, "1.1": tls.VersionTLS11, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+func (v *TLSVersion) String() string {
+	for name, version := range tlsVersions {
+		if uint16(*v) == version {
+			return name
+		}
+	}
+	return ""
+}
+
+// Set TLSVersion from argument
+func (v *TLSVersion) Set(s string) error {
+	version, ok := tlsVersions[s]
+	if !ok {
+		return fmt.Errorf("invalid TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", s)
+	}
+	*v = TLSVersion(version)
+	return nil
+}
+
+// Type description of argument
+func (v *TLSVersion) Type() string {
+	return "version"
+}
+
+// CipherSuites from argument
+type CipherSuites []uint16
+
+func (c *CipherSuites) String() string {
+	names := make([]string, len(*c))
+	for i, id := range *c {
+		names[i] = tls.CipherSuiteName(id)
+	}
+	return strings.Join(names, ",")
+}
+
+// Set CipherSuites from comma separated names
+func (c *CipherSuites) Set(s string) error {
+	for _, name := range strings.Split(s, ",") {
+		id, ok := cipherSuiteID(strings.TrimSpace(name))
+		if !ok {
+			return fmt.Errorf("invalid cipher suite %q", name)
+		}
+		*c = append(*c, id)
+	}
+	return nil
+}
+
+// Type description of argument
+func (c *CipherSuites) Type() string {
+	return "list"
+}
+
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+// Curves from argument
+type Curves []tls.CurveID
+
+var curves = map[string]tls.CurveID{"X25519": tls.X25519, "P-256": tls.CurveP256, "P-384": tls.CurveP384, "P-521": tls.CurveP521}
+
+func (c *Curves) String() string {
+	names := make([]string, len(*c))
+	for i, id := range *c {
+		for name, curve := range curves {
+			if curve == id {
+				names[i] = name
+			}
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Set Curves from comma separated names
+func (c *Curves) Set(s string) error {
+	for _, name := range strings.Split(s, ",") {
+		id, ok := curves[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("invalid curve %q, expected X25519, P-256, P-384 or P-521", name)
+		}
+		*c = append(*c, id)
+	}
+	return nil
+}
+
+// Type description of argument
+func (c *Curves) Type() string {
+	return "list"
+}
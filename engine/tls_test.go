@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestTLSVersionSet(t *testing.T) {
+	var version TLSVersion
+	if err := version.Set("1.2"); err != nil || uint16(version) != tls.VersionTLS12 || version.String() != "1.2" {
+		t.Errorf("TLSVersion.Set(%q) has invalid value %q or error %v", "1.2", version.String(), err)
+	}
+	if err := version.Set("1.4"); err == nil {
+		t.Errorf("TLSVersion.Set(%q) must return an error!", "1.4")
+	}
+}
+
+func TestCipherSuitesSet(t *testing.T) {
+	var suites CipherSuites
+	if err := suites.Set("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"); err != nil || len(suites) != 2 {
+		t.Errorf("CipherSuites.Set has invalid value %q or error %v", suites.String(), err)
+	}
+	if suites.String() != "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384" {
+		t.Errorf("CipherSuites.Set has invalid value %q", suites.String())
+	}
+	if err := suites.Set("TLS_NULL"); err == nil {
+		t.Errorf("CipherSuites.Set(%q) must return an error!", "TLS_NULL")
+	}
+}
+
+func TestCurvesSet(t *testing.T) {
+	var curves Curves
+	if err := curves.Set("X25519,P-256"); err != nil || curves.String() != "X25519,P-256" {
+		t.Errorf("Curves.Set has invalid value %q or error %v", curves.String(), err)
+	}
+	if err := curves.Set("P-192"); err == nil {
+		t.Errorf("Curves.Set(%q) must return an error!", "P-192")
+	}
+}
+
+func TestTLSOptionsConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})
+
+	config := TLSOptions{Insecure: true, CaCert: caCert, MinVersion: tls.VersionTLS12, ServerName: "example.test"}.config()
+	if !config.InsecureSkipVerify || config.MinVersion != tls.VersionTLS12 || config.ServerName != "example.test" || !config.SessionTicketsDisabled {
+		t.Errorf("TLS config has invalid settings: %+v", config)
+	}
+	systemRoots, err := x509.SystemCertPool()
+	if err == nil && len(systemRoots.Subjects()) > 0 && len(config.RootCAs.Subjects()) != len(systemRoots.Subjects())+1 {
+		t.Errorf("CA certificate must be added to the %d system roots, but pool has %d certificates", len(systemRoots.Subjects()), len(config.RootCAs.Subjects()))
+	}
+
+	config = TLSOptions{SessionResumption: true}.config()
+	if config.ClientSessionCache == nil || config.SessionTicketsDisabled {
+		t.Errorf("TLS config must resume sessions: %+v", config)
+	}
+}
+
+func TestExecTLSResumptionAndSNI(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	var mu sync.Mutex
+	serverNames := make(map[string]int)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a new connection and handshake for every request
+		w.Header().Set("Connection", "close")
+	}))
+	server.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		serverNames[hello.ServerName]++
+		return nil, nil
+	}}
+	server.StartTLS()
+	defer server.Close()
+	request.URL = server.URL
+
+	options := TLSOptions{Insecure: true, MinVersion: tls.VersionTLS13, ServerName: "example.test", SessionResumption: true}
+	probe, err := NewRunner(Options{Request: request, NumClients: 1, NumRepeats: 3, TLS: options}).Exec(context.Background(), 1)
+	if err != nil || probe.ErrRate > 0 {
+		t.Errorf("Exec with TLS options fails: %s", probe)
+	}
+	if probe.TLSCount["TLS 1.3 TLS_AES_128_GCM_SHA256"] != 3 || probe.Handshakes != 3 || probe.ResumedHandshakes != 2 {
+		t.Errorf("Probe has invalid TLS statistics: %v, resumed %d/%d", probe.TLSCount, probe.ResumedHandshakes, probe.Handshakes)
+	}
+	if serverNames["example.test"] != 3 {
+		t.Errorf("Server must receive SNI %q, but was %v", "example.test", serverNames)
+	}
+}
+
+func TestExecHandshakes(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	request.URL = server.URL
+
+	options := Options{Request: request, NumClients: 1, NumRepeats: 4, Handshakes: true, TLS: TLSOptions{Insecure: true, SessionResumption: true}}
+	probe, err := NewRunner(options).Exec(context.Background(), 1)
+	if err != nil || probe.ErrRate > 0 {
+		t.Errorf("Exec in handshake mode fails: %s", probe)
+	}
+	if probe.Handshakes != 4 || probe.ResumedHandshakes != 3 {
+		t.Errorf("Every request must have its own handshake, but resumed %d/%d", probe.ResumedHandshakes, probe.Handshakes)
+	}
+	if probe.AvgHandshakeNano <= 0 || probe.AvgResumedHandshakeNano <= 0 {
+		t.Errorf("Probe has invalid handshake durations: full %.0fns, resumed %.0fns", probe.AvgHandshakeNano, probe.AvgResumedHandshakeNano)
+	}
+	if full, resumed := probe.HandshakeRates(); full <= 0 || resumed <= 0 {
+		t.Errorf("Probe has invalid handshake rates: full %.1f/s, resumed %.1f/s", full, resumed)
+	}
+
+	options.NumClients = 3
+	probe, _ = NewRunner(options).Exec(context.Background(), 3)
+	if probe.Handshakes != 12 || probe.ResumedHandshakes != 9 {
+		t.Errorf("The first handshake of every client must be a full one, but resumed %d/%d", probe.ResumedHandshakes, probe.Handshakes)
+	}
+
+	options.NumClients = 1
+	options.TLS.SessionResumption = false
+	probe, _ = NewRunner(options).Exec(context.Background(), 1)
+	if probe.Handshakes != 4 || probe.ResumedHandshakes != 0 {
+		t.Errorf("Handshakes without session tickets must not be resumed, but resumed %d/%d", probe.ResumedHandshakes, probe.Handshakes)
+	}
+}
@@ -0,0 +1,395 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Phase of a request recorded with httptrace, e.g. dns, connect, proxy, tls or wait
+type Phase struct {
+	Name       string
+	Start, End time.Time
+}
+
+// phaseRecorder collects the phases of a single request
+type phaseRecorder struct {
+	mu     sync.Mutex
+	starts map[string]time.Time
+	phases []Phase
+	reused bool
+	conn   string
+
+	handshake, resumed bool
+	handshakeTime      time.Duration
+	quic               *quic.Conn // connection dialed for the request with HTTP/3
+	zeroRTT            bool
+}
+
+// recorderKey of the phaseRecorder in the context of a request, for the dialers without httptrace support
+type recorderKey struct{}
+
+func newPhaseRecorder() *phaseRecorder {
+	return &phaseRecorder{starts: make(map[string]time.Time)}
+}
+
+func (r *phaseRecorder) begin(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.starts[name] = time.Now()
+}
+
+func (r *phaseRecorder) end(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if start, ok := r.starts[name]; ok {
+		r.phases = append(r.phases, Phase{name, start, time.Now()})
+		delete(r.starts, name)
+	}
+}
+
+func (r *phaseRecorder) gotConn(info httptrace.GotConnInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reused = info.Reused
+	if info.Conn != nil {
+		if addr := info.Conn.LocalAddr(); addr != nil {
+			r.conn = addr.String()
+		}
+		if r.conn == "" {
+			// unnamed unix socket
+			r.conn = fmt.Sprintf("%p", info.Conn)
+		}
+	}
+}
+
+func (r *phaseRecorder) tlsHandshakeDone(state tls.ConnectionState, err error) {
+	r.end("tls")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handshake, r.resumed = err == nil, state.DidResume
+	if n := len(r.phases); n > 0 && r.phases[n-1].Name == "tls" {
+		r.handshakeTime = r.phases[n-1].End.Sub(r.phases[n-1].Start)
+	}
+}
+
+// tunnelDone ends the proxy phase of a CONNECT tunnel, which starts after the connection to the proxy
+func (r *phaseRecorder) tunnelDone() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.phases) - 1; i >= 0; i-- {
+		if r.phases[i].Name == "connect" {
+			r.phases = append(r.phases, Phase{"proxy", r.phases[i].End, time.Now()})
+			return
+		}
+	}
+}
+
+func (r *phaseRecorder) setQUIC(conn *quic.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.quic = conn
+}
+
+// quicHandshakeDone updates the resumption of an early QUIC connection, whose handshake completes after the dial
+func (r *phaseRecorder) quicHandshakeDone() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.quic == nil {
+		return
+	}
+	select {
+	case <-r.quic.HandshakeComplete():
+		state := r.quic.ConnectionState()
+		r.resumed, r.zeroRTT = state.TLS.DidResume, state.Used0RTT
+	default:
+	}
+}
+
+func (r *phaseRecorder) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { r.begin("dns") },
+		DNSDone:              func(httptrace.DNSDoneInfo) { r.end("dns") },
+		ConnectStart:         func(network, addr string) { r.begin("connect") },
+		ConnectDone:          func(network, addr string, err error) { r.end("connect") },
+		TLSHandshakeStart:    func() { r.begin("tls") },
+		TLSHandshakeDone:     r.tlsHandshakeDone,
+		GotConn:              r.gotConn,
+		WroteRequest:         func(httptrace.WroteRequestInfo) { r.begin("wait") },
+		GotFirstResponseByte: func() { r.end("wait") },
+	}
+}
+
+// traceBatchSpans and traceFlushInterval bound the spans held between exports, e.g. during long stages
+const (
+	traceBatchSpans    = 1000
+	traceFlushInterval = time.Second
+)
+
+// Tracer injects W3C trace context into requests and exports client spans in batches and after each step
+type Tracer struct {
+	baggage  string
+	exporter SpanExporter
+	mu       sync.Mutex
+	spans    []otlpSpan
+	flushed  time.Time
+	err      error
+	exports  sync.Mutex     // one export at a time
+	pending  sync.WaitGroup // exports of batches in the background
+}
+
+// NewTracer with baggage members in the form key=value
+func NewTracer(baggage []string, exporter SpanExporter) *Tracer {
+	members := make([]string, 0, len(baggage))
+	for _, b := range baggage {
+		key, value := parseProperty(b)
+		if key != "" {
+			members = append(members, key+"="+url.PathEscape(value))
+		}
+	}
+	return &Tracer{baggage: strings.Join(members, ","), exporter: exporter, flushed: time.Now()}
+}
+
+// inject a fresh trace into the request and return its trace and span ID
+func (t *Tracer) inject(req *http.Request) (string, string) {
+	traceID, spanID := randomHex(16), randomHex(8)
+	req.Header.Set("Traceparent", "00-"+traceID+"-"+spanID+"-01")
+	if t.baggage != "" {
+		req.Header.Set("Baggage", t.baggage)
+	}
+	return traceID, spanID
+}
+
+// record the client span of the request with its phases as child spans
+func (t *Tracer) record(traceID, spanID string, req *http.Request, sample *Sample) {
+	end := sample.Start.Add(sample.TimeTotal)
+	if sample.TimeTotal == 0 {
+		end = time.Now()
+	}
+	span := otlpSpan{
+		TraceID:   traceID,
+		SpanID:    spanID,
+		Name:      req.Method,
+		Kind:      3, // client
+		StartTime: strconv.FormatInt(sample.Start.UnixNano(), 10),
+		EndTime:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes: []otlpAttribute{
+			stringAttribute("http.request.method", req.Method),
+			stringAttribute("url.full", req.URL.String()),
+			intAttribute("http.response.status_code", sample.ResponseCode),
+		},
+	}
+	if !sample.IsSuccessful() {
+		span.Status.Code = 2 // error
+		span.Attributes = append(span.Attributes, stringAttribute("error.type", sample.Category()))
+	}
+	spans := []otlpSpan{span}
+	for _, p := range sample.Phases {
+		spans = append(spans, otlpSpan{
+			TraceID:      traceID,
+			SpanID:       randomHex(8),
+			ParentSpanID: spanID,
+			Name:         p.Name,
+			Kind:         3,
+			StartTime:    strconv.FormatInt(p.Start.UnixNano(), 10),
+			EndTime:      strconv.FormatInt(p.End.UnixNano(), 10),
+		})
+	}
+
+	t.mu.Lock()
+	t.spans = append(t.spans, spans...)
+	var batch []otlpSpan
+	if len(t.spans) >= traceBatchSpans || time.Since(t.flushed) >= traceFlushInterval {
+		batch = t.take()
+	}
+	t.mu.Unlock()
+	if len(batch) > 0 {
+		// in the background, so that the export does not delay the next request of the client
+		t.pending.Add(1)
+		go func() {
+			defer t.pending.Done()
+			t.export(batch)
+		}()
+	}
+}
+
+// take the recorded spans, t.mu must be held
+func (t *Tracer) take() []otlpSpan {
+	spans := t.spans
+	t.spans, t.flushed = nil, time.Now()
+	return spans
+}
+
+// flush exports the recorded spans at the end of a step, the first export error is returned by Close
+func (t *Tracer) flush() {
+	t.mu.Lock()
+	spans := t.take()
+	t.mu.Unlock()
+	if len(spans) > 0 {
+		t.export(spans)
+	}
+}
+
+// export the spans and keep the first error for Close
+func (t *Tracer) export(spans []otlpSpan) {
+	t.exports.Lock()
+	err := t.send(spans)
+	t.exports.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+}
+
+func (t *Tracer) send(spans []otlpSpan) error {
+	payload, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{stringAttribute("service.name", "chail")}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "chail"}, Spans: spans}},
+	}}})
+	if err != nil {
+		return err
+	}
+	return t.exporter.Export(payload)
+}
+
+// Close exports the remaining spans and returns the first export error
+func (t *Tracer) Close() error {
+	t.flush()
+	t.pending.Wait()
+	err := t.exporter.Close()
+	if t.err != nil {
+		err = t.err
+	}
+	return err
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SpanExporter sends OTLP/JSON encoded spans
+type SpanExporter interface {
+	Export(payload []byte) error
+	Close() error
+}
+
+// NewSpanExporter for an OTLP/HTTP endpoint or for a file with one JSON document per line
+func NewSpanExporter(target string) (SpanExporter, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return &otlpHTTPExporter{url: target, client: &http.Client{Timeout: 5 * time.Second}}, nil
+	}
+	file, err := os.Create(target)
+	if err != nil {
+		return nil, err
+	}
+	return &fileExporter{file}, nil
+}
+
+type otlpHTTPExporter struct {
+	url    string
+	client *http.Client
+}
+
+func (e *otlpHTTPExporter) Export(payload []byte) error {
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responds with %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *otlpHTTPExporter) Close() error {
+	return nil
+}
+
+type fileExporter struct {
+	file *os.File
+}
+
+func (e *fileExporter) Export(payload []byte) error {
+	_, err := e.file.Write(append(payload, '\n'))
+	return err
+}
+
+func (e *fileExporter) Close() error {
+	return e.file.Close()
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	StartTime    string          `json:"startTimeUnixNano"`
+	EndTime      string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{key, otlpAnyValue{StringValue: &value}}
+}
+
+func intAttribute(key string, value int) otlpAttribute {
+	s := strconv.Itoa(value)
+	return otlpAttribute{key, otlpAnyValue{IntValue: &s}}
+}
@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var traceparentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-01$`)
+
+func TestDoWithTracing(t *testing.T) {
+	setUp("GET", "Content-Type: application/json", "")
+	traceparents := make(chan string, 1)
+	baggages := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("Traceparent")
+		baggages <- r.Header.Get("Baggage")
+	}))
+	defer server.Close()
+	request.URL = server.URL
+
+	filename := filepath.Join(t.TempDir(), "spans.json")
+	exporter, err := NewSpanExporter(filename)
+	if err != nil {
+		t.Fatalf("NewSpanExporter has error: %v", err)
+	}
+	tracer := NewTracer([]string{"tenant=acme corp", "invalid"}, exporter)
+
+	sample := NewRunner(Options{Request: request, Tracer: tracer}).Do(context.Background())
+	if !sample.IsSuccessful() {
+		t.Errorf("Do fails: %s %s", request.Method.String(), server.URL)
+	}
+	if err = tracer.Close(); err != nil {
+		t.Errorf("Closing tracer failed: %v", err)
+	}
+
+	match := traceparentPattern.FindStringSubmatch(<-traceparents)
+	if match == nil {
+		t.Fatalf("Request has no valid traceparent header")
+	}
+	if baggage := <-baggages; baggage != "tenant=acme%20corp" {
+		t.Errorf("Request has baggage %q, expected %q", baggage, "tenant=acme%20corp")
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Reading %s failed: %v", filename, err)
+	}
+	var traces otlpTraces
+	if err = json.Unmarshal(content, &traces); err != nil {
+		t.Fatalf("Span file is invalid: %v", err)
+	}
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	if spans[0].TraceID != match[1] || spans[0].SpanID != match[2] || spans[0].Name != "GET" {
+		t.Errorf("Client span %+v does not match traceparent %s", spans[0], match[0])
+	}
+	names := []string{}
+	for _, span := range spans[1:] {
+		if span.ParentSpanID != match[2] {
+			t.Errorf("Phase span %q has parent %q, expected %q", span.Name, span.ParentSpanID, match[2])
+		}
+		names = append(names, span.Name)
+	}
+	if !contains(names, "connect") || !contains(names, "wait") {
+		t.Errorf("Phase spans %v miss connect or wait", names)
+	}
+}
+
+func TestOTLPHTTPExporter(t *testing.T) {
+	payloads := make(chan string, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		payloads <- string(body)
+	}))
+	defer collector.Close()
+
+	exporter, err := NewSpanExporter(collector.URL + "/v1/traces")
+	if err != nil {
+		t.Fatalf("NewSpanExporter has error: %v", err)
+	}
+	tracer := NewTracer(nil, exporter)
+	tracer.record("0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331", httptest.NewRequest("GET", "http://localhost:8080/", nil), &Sample{ResponseCode: 500})
+	tracer.flush()
+
+	payload := <-payloads
+	if !strings.Contains(payload, `"traceId":"0af7651916cd43dd8448eb211c80319c"`) || !strings.Contains(payload, `"status":{"code":2}`) {
+		t.Errorf("Collector received invalid payload: %s", payload)
+	}
+	if err = tracer.Close(); err != nil {
+		t.Errorf("Exporting spans failed: %v", err)
+	}
+}
+
+func TestTracerCloseWithExportError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exporter, err := NewSpanExporter(collector.URL + "/v1/traces")
+	if err != nil {
+		t.Fatalf("NewSpanExporter has error: %v", err)
+	}
+	tracer := NewTracer(nil, exporter)
+	tracer.record("0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331", httptest.NewRequest("GET", "http://localhost:8080/", nil), &Sample{ResponseCode: 200})
+	// the export of a step fails, nothing is left to export on Close
+	tracer.flush()
+	if err = tracer.Close(); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Close must return the export error of a step, but was %v", err)
+	}
+}
+
+func TestTracerExportsBatches(t *testing.T) {
+	exporter := &countingExporter{}
+	tracer := NewTracer(nil, exporter)
+	req := httptest.NewRequest("GET", "http://localhost:8080/", nil)
+	for range traceBatchSpans {
+		tracer.record("0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331", req, &Sample{ResponseCode: 200})
+	}
+	tracer.pending.Wait()
+	if exporter.count() != 1 {
+		t.Errorf("Tracer must export a full batch before the end of the step, but exported %d times", exporter.count())
+	}
+
+	tracer.mu.Lock()
+	tracer.flushed = time.Now().Add(-traceFlushInterval)
+	tracer.mu.Unlock()
+	tracer.record("0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331", req, &Sample{ResponseCode: 200})
+	tracer.pending.Wait()
+	if exporter.count() != 2 {
+		t.Errorf("Tracer must export the spans after the flush interval, but exported %d times", exporter.count())
+	}
+	if err := tracer.Close(); err != nil || exporter.count() != 2 {
+		t.Errorf("Close without recorded spans must not export, but exported %d times with error %v", exporter.count(), err)
+	}
+}
+
+type countingExporter struct {
+	sync.Mutex
+	exports int
+}
+
+func (e *countingExporter) Export([]byte) error { e.Lock(); e.exports++; e.Unlock(); return nil }
+func (e *countingExporter) Close() error        { return nil }
+func (e *countingExporter) count() int          { e.Lock(); defer e.Unlock(); return e.exports }
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+)
+
+// Protocols of the requests
+const (
+	HTTP1 = "http1.1" // HTTP/1.1 only, no upgrade to HTTP/2 with ALPN
+	HTTP2 = "http2"   // HTTP/2 over TLS, requests with another negotiated protocol fail
+	H2C   = "h2c"     // HTTP/2 over cleartext with prior knowledge
+	HTTP3 = "http3"   // HTTP/3 over QUIC
+)
+
+// newTransport of the protocol with the TLS configuration and the source addresses, the protocol is negotiated with ALPN if empty
+func (r *Runner) newTransport(config *tls.Config, src source) http.RoundTripper {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return r.dial(ctx, src, network, addr)
+	}
+	switch r.options.Protocol {
+	case HTTP3:
+		return &http3.Transport{TLSClientConfig: config, Dial: func(ctx context.Context, addr string, config *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+			return r.dialQUIC(ctx, src, addr, config, quicConfig)
+		}}
+	case H2C:
+		return &http2.Transport{
+			AllowHTTP:       true,
+			IdleConnTimeout: r.options.IdleTimeout,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if r.options.UnixSocket == "" {
+		transport.Proxy = r.proxy
+	}
+	transport.DialContext = dial
+	transport.OnProxyConnectResponse = func(ctx context.Context, _ *url.URL, _ *http.Request, _ *http.Response) error {
+		if recorder, ok := ctx.Value(recorderKey{}).(*phaseRecorder); ok {
+			recorder.tunnelDone()
+		}
+		return nil
+	}
+	transport.MaxConnsPerHost = r.maxClients()
+	if r.options.MaxConns > 0 {
+		transport.MaxConnsPerHost = r.options.MaxConns
+	}
+	if r.options.MaxIdleConns > 0 {
+		transport.MaxIdleConns, transport.MaxIdleConnsPerHost = r.options.MaxIdleConns, r.options.MaxIdleConns
+	}
+	if r.options.IdleTimeout > 0 {
+		transport.IdleConnTimeout = r.options.IdleTimeout
+	}
+	transport.DisableKeepAlives = r.options.Handshakes || r.options.NoKeepAlive
+	transport.TLSClientConfig = config
+	switch r.options.Protocol {
+	case HTTP1:
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	case HTTP2:
+		if len(config.NextProtos) == 0 {
+			config.NextProtos = []string{http2.NextProtoTLS}
+		}
+	}
+	return transport
+}
+
+// proxy of the request, from the environment if no proxy is set, none for the hosts of NoProxy
+func (r *Runner) proxy(req *http.Request) (*url.URL, error) {
+	if noProxy(req.URL.Hostname(), r.options.NoProxy) {
+		return nil, nil
+	}
+	if r.options.Proxy != nil {
+		return r.options.Proxy, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// noProxy is true if the host matches one of the host names, domains, IP addresses or CIDRs, '*' matches all hosts
+func noProxy(host string, patterns []string) bool {
+	ip := net.ParseIP(host)
+	for _, pattern := range patterns {
+		if _, network, err := net.ParseCIDR(pattern); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(pattern, ".")
+		if pattern == "*" || strings.EqualFold(host, domain) || strings.HasSuffix(strings.ToLower(host), "."+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialQUIC is an early connection, ready for 0-RTT requests before the handshake is complete if the session is resumed
+func (r *Runner) dialQUIC(ctx context.Context, src source, addr string, config *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+	network := r.network("udp")
+	addrs, err := r.resolve(ctx, r.target(addr), src.dual())
+	if err != nil {
+		return nil, err
+	}
+	recorder, _ := ctx.Value(recorderKey{}).(*phaseRecorder)
+	if recorder != nil {
+		recorder.begin("tls")
+	}
+	var conn *quic.Conn
+	for i, addr := range addrs {
+		// like the TCP dialer, every address gets its share of the remaining time
+		attempt, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok && i < len(addrs)-1 {
+			attempt, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(len(addrs)-i))
+		}
+		conn, err = dialQUICAddr(attempt, src, network, addr, config, quicConfig)
+		cancel()
+		if err == nil {
+			break
+		}
+	}
+	if recorder != nil {
+		var state tls.ConnectionState
+		if conn != nil {
+			state = conn.ConnectionState().TLS
+		}
+		recorder.tlsHandshakeDone(state, err)
+		recorder.setQUIC(conn)
+	}
+	return conn, err
+}
+
+// dialQUICAddr dials a single address, the UDP socket is closed with the connection
+func dialQUICAddr(ctx context.Context, src source, network, addr string, config *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP(network, &net.UDPAddr{IP: src.localAddr(udpAddr.IP.String())})
+	if err != nil {
+		return nil, err
+	}
+	conn, err := quic.DialEarly(ctx, udpConn, udpAddr, config, quicConfig)
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	go func() {
+		<-conn.Context().Done()
+		udpConn.Close()
+	}()
+	return conn, nil
+}
+
+// early request, sent as 0-RTT data on a resumed QUIC connection if it is a GET or HEAD request
+func (r *Runner) early(req *http.Request) *http.Request {
+	if r.options.Protocol != HTTP3 || !r.options.TLS.SessionResumption {
+		return req
+	}
+	method, ok := map[string]string{http.MethodGet: http3.MethodGet0RTT, http.MethodHead: http3.MethodHead0RTT}[req.Method]
+	if !ok {
+		return req
+	}
+	early := *req
+	early.Method = method
+	return &early
+}
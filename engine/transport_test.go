@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func newHTTP2Server(t *testing.T) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestExecHTTP2(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	request.URL = newHTTP2Server(t).URL
+
+	options := Options{Request: request, NumClients: 4, NumRepeats: 3, Protocol: HTTP2, TLS: TLSOptions{Insecure: true}}
+	probe, err := NewRunner(options).Exec(context.Background(), 4)
+	if err != nil || probe.ErrRate > 0 {
+		t.Errorf("Exec with HTTP/2 fails: %s", probe)
+	}
+	if probe.ProtoCount["HTTP/2.0"] != 12 {
+		t.Errorf("All requests must use HTTP/2, but were %v", probe.ProtoCount)
+	}
+	if probe.Connections >= 4 || probe.MaxStreams < 2 {
+		t.Errorf("Requests must be multiplexed, but were %d connections with at most %d streams", probe.Connections, probe.MaxStreams)
+	}
+}
+
+func TestExecHTTP1(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	request.URL = newHTTP2Server(t).URL
+
+	options := Options{Request: request, NumClients: 2, NumRepeats: 2, Protocol: HTTP1, TLS: TLSOptions{Insecure: true}}
+	probe, err := NewRunner(options).Exec(context.Background(), 2)
+	if err != nil || probe.ErrRate > 0 {
+		t.Errorf("Exec with HTTP/1.1 fails: %s", probe)
+	}
+	if probe.ProtoCount["HTTP/1.1"] != 4 || probe.Connections != 2 || probe.MaxStreams != 1 {
+		t.Errorf("All requests must use HTTP/1.1 on a connection per client, but were %v on %d connections with %d streams",
+			probe.ProtoCount, probe.Connections, probe.MaxStreams)
+	}
+}
+
+func TestExecHTTP2WithoutServerSupport(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	request.URL = server.URL
+
+	options := Options{Request: request, NumClients: 1, NumRepeats: 1, Protocol: HTTP2, TLS: TLSOptions{Insecure: true}}
+	sample := NewRunner(options).Do(context.Background())
+	if sample.Failure != FailureProto || sample.Proto != "HTTP/1.1" {
+		t.Errorf("HTTP/2 request to HTTP/1.1 server must fail, but was %q with %q", sample.Failure, sample.Proto)
+	}
+
+	options.NumRepeats = 2
+	probe, _ := NewRunner(options).Exec(context.Background(), 1)
+	if probe.ErrRate != 1 || len(probe.TimeTotalNanos) > 0 {
+		t.Errorf("HTTP/1.1 responses must be counted as errors: %s", probe)
+	}
+}
+
+func TestExecH2C(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), &http2.Server{}))
+	defer server.Close()
+	request.URL = server.URL
+
+	options := Options{Request: request, NumClients: 2, NumRepeats: 2, Protocol: H2C}
+	probe, err := NewRunner(options).Exec(context.Background(), 2)
+	if err != nil || probe.ErrRate > 0 {
+		t.Errorf("Exec with h2c fails: %s", probe)
+	}
+	if probe.ProtoCount["HTTP/2.0"] != 4 || len(probe.TLSCount) > 0 {
+		t.Errorf("All requests must use HTTP/2 without TLS, but were %v and %v", probe.ProtoCount, probe.TLSCount)
+	}
+}
+
+func TestExecHTTP3(t *testing.T) {
+	setUp("POST", "Content-Type: application/json", `{"key1":"value1", "key2":"value2"}`)
+	startHTTP3Server(t, "Content-Type", "application/json")
+
+	options := Options{Request: request, NumClients: 2, NumRepeats: 3, Protocol: HTTP3, TLS: TLSOptions{Insecure: true}}
+	probe, err := NewRunner(options).Exec(context.Background(), 2)
+	if err != nil || probe.ErrRate > 0 {
+		t.Errorf("Exec with HTTP/3 fails: %s %v", probe, probe.ResponseCodeCount)
+	}
+	if probe.ProtoCount["HTTP/3.0"] != 6 || probe.Handshakes != 1 || probe.AvgHandshakeNano <= 0 {
+		t.Errorf("All requests must use HTTP/3 on a single QUIC connection, but were %v with %d handshakes", probe.ProtoCount, probe.Handshakes)
+	}
+}
+
+func TestDoHTTP3ZeroRTT(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	startHTTP3Server(t, "Content-Type", "application/xml")
+
+	r := NewRunner(Options{Request: request, Protocol: HTTP3, TLS: TLSOptions{Insecure: true, SessionResumption: true}})
+	first := r.Do(context.Background())
+	if !first.IsSuccessful() || !first.TLSHandshake || first.TLSResumed || first.ZeroRTT {
+		t.Errorf("First request must have a full handshake: %+v", first)
+	}
+	r.clients[0].CloseIdleConnections()
+	second := r.Do(context.Background())
+	if !second.IsSuccessful() || !second.TLSHandshake || !second.TLSResumed || !second.ZeroRTT {
+		t.Errorf("Request on a new connection must be sent with 0-RTT: %+v", second)
+	}
+}
+
+func TestExecConnectionReuse(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	server := startServer(t, "Content-Type", "application/xml")
+	defer server.Close()
+
+	options := Options{Request: request, NumClients: 2, NumRepeats: 3}
+	probe, _ := NewRunner(options).Exec(context.Background(), 2)
+	if probe.NewConns < 1 || probe.NewConns > 2 || probe.NewConns+probe.ReusedConns != 6 {
+		t.Errorf("Clients must share their connections, but were %d new and %d reused", probe.NewConns, probe.ReusedConns)
+	}
+
+	options.NoKeepAlive = true
+	probe, _ = NewRunner(options).Exec(context.Background(), 2)
+	if probe.NewConns != 6 || probe.ReusedConns != 0 {
+		t.Errorf("Every request must have a new connection, but were %d new and %d reused", probe.NewConns, probe.ReusedConns)
+	}
+}
+
+func TestExecClientPools(t *testing.T) {
+	setUp("GET", "Content-Type: application/xml", `<xml><entry key="1" value="2"/></xml>`)
+	request.URL = newHTTP2Server(t).URL
+
+	options := Options{Request: request, NumClients: 3, NumRepeats: 2, Protocol: HTTP2, ClientPools: true, TLS: TLSOptions{Insecure: true}}
+	probe, err := NewRunner(options).Exec(context.Background(), 3)
+	if err != nil || probe.ErrRate > 0 {
+		t.Errorf("Exec with client pools fails: %s", probe)
+	}
+	if probe.Connections != 3 || probe.MaxStreams != 1 || probe.NewConns != 3 || probe.ReusedConns != 3 {
+		t.Errorf("Every client must have a connection of its own, but were %d connections with %d new and %d reused",
+			probe.Connections, probe.NewConns, probe.ReusedConns)
+	}
+}
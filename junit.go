@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mcjr/chail/engine"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes every assertion or, if there are none, every step as a test case
+func writeJUnit(filename, url string, assertions Assertions, probes []engine.Probe, violations []violation) error {
+	run := engine.Merge(probes)
+	suite := junitTestSuite{
+		Name:      "chail " + url,
+		Time:      formatSeconds(run.Duration),
+		Timestamp: time.Now().Add(-run.Duration).Format("2006-01-02T15:04:05"),
+	}
+
+	if len(assertions) > 0 {
+		for _, assertion := range assertions {
+			testCase := junitTestCase{
+				Name:      assertion.String(),
+				ClassName: url,
+				Time:      formatSeconds(run.Duration),
+				SystemOut: run.String() + formatResponseCodeCount(&run),
+			}
+			var messages []string
+			for _, v := range violations {
+				if v.assertion == assertion {
+					messages = append(messages, v.String())
+				}
+			}
+			if len(messages) > 0 {
+				testCase.Failure = &junitFailure{
+					Message: messages[0],
+					Type:    "assertion",
+					Text:    strings.Join(messages, "\n"),
+				}
+			}
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+	} else {
+		for i := range probes {
+			testCase := junitTestCase{
+				Name:      fmt.Sprintf("%d clients", probes[i].Clients),
+				ClassName: url,
+				Time:      formatSeconds(probes[i].Duration),
+				SystemOut: probes[i].String() + formatResponseCodeCount(&probes[i]),
+			}
+			if probes[i].ErrRate > 0 {
+				testCase.Failure = &junitFailure{
+					Message: fmt.Sprintf("error=%.1f%%", probes[i].ErrRate*100),
+					Type:    "errors",
+					Text:    probes[i].String() + formatResponseCodeCount(&probes[i]),
+				}
+			}
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+	}
+
+	suite.Tests = len(suite.TestCases)
+	for _, testCase := range suite.TestCases {
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+	}
+
+	content, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append([]byte(xml.Header), append(content, '\n')...), 0644)
+}
+
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mcjr/chail/engine"
+)
+
+var junitTestProbes = []engine.Probe{
+	{Clients: 1, Requests: 2, Duration: time.Second, AvgTimeTotalNano: 10e6, TimeTotalNanos: []int64{10e6, 10e6}, ResponseCodeCount: map[int]int{200: 2}},
+	{Clients: 2, Requests: 4, Duration: time.Second, AvgTimeTotalNano: 40e6, ErrRate: 0.5, TimeTotalNanos: []int64{30e6, 50e6}, ResponseCodeCount: map[int]int{200: 2, 500: 2}},
+}
+
+func TestWriteJUnitSteps(t *testing.T) {
+	suites := writeAndReadJUnit(t, nil, nil)
+	assertJUnitSuite(t, suites, 2, 1)
+	if failure := suites.Suites[0].TestCases[1].Failure; failure == nil || failure.Text != "2: avg(starttransfer)=0.00ms, avg(total)=40.00ms, error=50.0%, rcc(200)=2, rcc(500)=2" {
+		t.Errorf("JUnit test case of step 2 has invalid failure: %+v", failure)
+	}
+}
+
+func TestWriteJUnitAssertions(t *testing.T) {
+	var assertions Assertions
+	assertions.Set("p95<300ms")
+	assertions.Set("errors<10%")
+	violations := checkAssertions(assertions, junitTestProbes, false)
+
+	suites := writeAndReadJUnit(t, assertions, violations)
+	assertJUnitSuite(t, suites, 2, 1)
+	if testCase := suites.Suites[0].TestCases[1]; testCase.Name != "errors<10%" || testCase.Failure == nil {
+		t.Errorf("JUnit test case of assertion 'errors<10%%' has invalid value: %+v", testCase)
+	}
+}
+
+func writeAndReadJUnit(t *testing.T, assertions Assertions, violations []violation) junitTestSuites {
+	var suites junitTestSuites
+	filename := filepath.Join(t.TempDir(), "results.xml")
+	err := writeJUnit(filename, "http://localhost:8080", assertions, junitTestProbes, violations)
+	if err != nil {
+		t.Errorf("writeJUnit has error: %v", err)
+		return suites
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Reading %s failed: %v", filename, err)
+		return suites
+	}
+	if err = xml.Unmarshal(content, &suites); err != nil {
+		t.Errorf("JUnit XML is invalid: %v", err)
+	}
+	return suites
+}
+
+func assertJUnitSuite(t *testing.T, suites junitTestSuites, expectedTests, expectedFailures int) {
+	if len(suites.Suites) != 1 {
+		t.Errorf("JUnit XML has %d test suites, expected 1", len(suites.Suites))
+		return
+	}
+	suite := suites.Suites[0]
+	if suite.Tests != expectedTests || len(suite.TestCases) != expectedTests || suite.Failures != expectedFailures {
+		t.Errorf("JUnit test suite has %d tests and %d failures, expected %d tests and %d failures", suite.Tests, suite.Failures, expectedTests, expectedFailures)
+	}
+}
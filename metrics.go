@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/mcjr/chail/engine"
+)
+
+// metricsBuckets are the upper bounds of the latency histogram in seconds
+var metricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metricsKey struct {
+	code     int
+	category string
+}
+
+// metrics of a run in Prometheus exposition format, fed by the observer notifications
+type metrics struct {
+	engine.NopObserver
+	mu                sync.Mutex
+	clients, inFlight int
+	requests          map[metricsKey]uint64
+	bucketCounts      []uint64
+	durationSum       float64
+	durationCount     uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:     make(map[metricsKey]uint64),
+		bucketCounts: make([]uint64, len(metricsBuckets)),
+	}
+}
+
+// serveMetrics on the address until the returned server is closed
+func serveMetrics(addr string, m *metrics) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	return server, nil
+}
+
+func (m *metrics) StepStarted(clients int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clients = clients
+}
+
+func (m *metrics) RequestStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight++
+}
+
+func (m *metrics) RequestDone(sample engine.Sample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight--
+	m.requests[metricsKey{sample.ResponseCode, sample.Category()}]++
+	if sample.TimeTotal > 0 {
+		seconds := sample.TimeTotal.Seconds()
+		for i, bound := range metricsBuckets {
+			if seconds <= bound {
+				m.bucketCounts[i]++
+			}
+		}
+		m.durationSum += seconds
+		m.durationCount++
+	}
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP chail_requests_total Number of requests by response code and error category.")
+	fmt.Fprintln(w, "# TYPE chail_requests_total counter")
+	keys := make([]metricsKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].code != keys[j].code {
+			return keys[i].code < keys[j].code
+		}
+		return keys[i].category < keys[j].category
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "chail_requests_total{code=\"%d\",category=\"%s\"} %d\n", key.code, key.category, m.requests[key])
+	}
+
+	fmt.Fprintln(w, "# HELP chail_request_duration_seconds Total time of requests with response.")
+	fmt.Fprintln(w, "# TYPE chail_request_duration_seconds histogram")
+	for i, bound := range metricsBuckets {
+		fmt.Fprintf(w, "chail_request_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(bound, 'f', -1, 64), m.bucketCounts[i])
+	}
+	fmt.Fprintf(w, "chail_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.durationCount)
+	fmt.Fprintf(w, "chail_request_duration_seconds_sum %s\n", strconv.FormatFloat(m.durationSum, 'f', -1, 64))
+	fmt.Fprintf(w, "chail_request_duration_seconds_count %d\n", m.durationCount)
+
+	fmt.Fprintln(w, "# HELP chail_clients Number of clients of the current step.")
+	fmt.Fprintln(w, "# TYPE chail_clients gauge")
+	fmt.Fprintf(w, "chail_clients %d\n", m.clients)
+
+	fmt.Fprintln(w, "# HELP chail_requests_in_flight Number of requests waiting for response.")
+	fmt.Fprintln(w, "# TYPE chail_requests_in_flight gauge")
+	fmt.Fprintf(w, "chail_requests_in_flight %d\n", m.inFlight)
+}
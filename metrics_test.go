@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcjr/chail/engine"
+)
+
+func TestServeMetrics(t *testing.T) {
+	server, err := serveMetrics("127.0.0.1:0", newMetrics())
+	if err != nil {
+		t.Errorf("serveMetrics has error: %v", err)
+		return
+	}
+	server.Close()
+
+	if _, err = serveMetrics("invalid address", newMetrics()); err == nil {
+		t.Errorf("serveMetrics must return an error for an invalid address!")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := newMetrics()
+	server := httptest.NewServer(m)
+	defer server.Close()
+
+	m.StepStarted(3)
+	m.RequestStarted()
+	m.RequestStarted()
+	m.RequestStarted()
+	m.RequestDone(engine.Sample{ResponseCode: 200, TimeTotal: 20 * time.Millisecond})
+	m.RequestDone(engine.Sample{ResponseCode: 503, TimeTotal: 2 * time.Second})
+	m.RequestDone(engine.Sample{Failure: engine.FailureTimeout})
+	m.RequestStarted()
+
+	body := getMetrics(t, server.URL+"/metrics")
+	for _, expected := range []string{
+		`chail_requests_total{code="0",category="timeout"} 1`,
+		`chail_requests_total{code="200",category=""} 1`,
+		`chail_requests_total{code="503",category="server"} 1`,
+		`chail_request_duration_seconds_bucket{le="0.01"} 0`,
+		`chail_request_duration_seconds_bucket{le="0.025"} 1`,
+		`chail_request_duration_seconds_bucket{le="2.5"} 2`,
+		`chail_request_duration_seconds_bucket{le="+Inf"} 2`,
+		`chail_request_duration_seconds_sum 2.02`,
+		`chail_clients 3`,
+		`chail_requests_in_flight 1`,
+	} {
+		if !strings.Contains(body, expected+"\n") {
+			t.Errorf("Metrics miss %q in:\n%s", expected, body)
+		}
+	}
+}
+
+func getMetrics(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Errorf("Fetching metrics failed: %v", err)
+		return ""
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Metrics have invalid content type %q", resp.Header.Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mcjr/chail/engine"
+)
+
+// statsdMaxPayload keeps datagrams below the usual MTU
+const statsdMaxPayload = 1400
+
+// sink streams samples and probes to an external system
+type sink interface {
+	writeSample(sample engine.Sample)
+	writeProbe(probe engine.Probe)
+	flush() error
+	close() error
+}
+
+// sinkBatchSamples and sinkFlushInterval bound the samples a sink holds between flushes, e.g. during long stages
+const (
+	sinkBatchSamples  = 1000
+	sinkFlushInterval = time.Second
+)
+
+// sinkWriter feeds a sink from the collection loop of each step and flushes it in batches and after the step
+type sinkWriter struct {
+	engine.NopObserver
+	sink    sink
+	pending int
+	flushed time.Time
+}
+
+func (w *sinkWriter) StepStarted(clients int) {
+	w.flushed = time.Now()
+}
+
+func (w *sinkWriter) SampleCollected(sample engine.Sample) {
+	w.sink.writeSample(sample)
+	w.pending++
+	if w.pending >= sinkBatchSamples || time.Since(w.flushed) >= sinkFlushInterval {
+		w.flush()
+	}
+}
+
+func (w *sinkWriter) StepDone(probe engine.Probe) {
+	w.sink.writeProbe(probe)
+	w.flush()
+}
+
+func (w *sinkWriter) flush() {
+	w.pending, w.flushed = 0, time.Now()
+	if err := w.sink.flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "writing to sink failed: %v\n", err)
+	}
+}
+
+// SinkSpec from arguments, e.g. influx=http://localhost:8086/write?db=chail
+type SinkSpec struct {
+	Kind, Address string
+}
+
+// Sinks from arguments
+type Sinks []SinkSpec
+
+func (s *Sinks) String() string {
+	terms := make([]string, len(*s))
+	for i, spec := range *s {
+		terms[i] = spec.Kind + "=" + spec.Address
+	}
+	return strings.Join(terms, ",")
+}
+
+// Set Sink from argument
+func (s *Sinks) Set(arg string) error {
+	kind, address, _ := strings.Cut(arg, "=")
+	kind, address = strings.TrimSpace(kind), strings.TrimSpace(address)
+	switch kind {
+	case "influx", "graphite", "statsd":
+		if address == "" {
+			return fmt.Errorf("missing address of sink %q", arg)
+		}
+		*s = append(*s, SinkSpec{kind, address})
+		return nil
+	}
+	return fmt.Errorf("invalid sink %q, expected influx=<url>, graphite=<host:port> or statsd=<host:port>", arg)
+}
+
+// Type description of argument
+func (s *Sinks) Type() string {
+	return "kind=address"
+}
+
+// sinkTags identify the scenario and the endpoint of the samples
+type sinkTags struct {
+	scenario, endpoint string
+}
+
+func newSinkTags(scenario, rawURL string) sinkTags {
+	endpoint := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		endpoint = u.Host + u.Path
+	}
+	return sinkTags{scenario, endpoint}
+}
+
+// newSink connects to the address of the spec
+func newSink(spec SinkSpec, tags sinkTags) (sink, error) {
+	switch spec.Kind {
+	case "influx":
+		return &influxSink{url: spec.Address, tags: tags, client: &http.Client{Timeout: 5 * time.Second}}, nil
+	case "graphite":
+		conn, err := net.Dial("tcp", spec.Address)
+		if err != nil {
+			return nil, err
+		}
+		return &graphiteSink{conn: conn, tags: tags}, nil
+	case "statsd":
+		conn, err := net.Dial("udp", spec.Address)
+		if err != nil {
+			return nil, err
+		}
+		return &statsdSink{conn: conn, tags: tags}, nil
+	}
+	return nil, fmt.Errorf("invalid sink %q", spec.Kind)
+}
+
+// influxSink posts line protocol over HTTP
+type influxSink struct {
+	url    string
+	tags   sinkTags
+	client *http.Client
+	buffer bytes.Buffer
+}
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func (s *influxSink) tagSet() string {
+	return "scenario=" + influxTagEscaper.Replace(s.tags.scenario) + ",endpoint=" + influxTagEscaper.Replace(s.tags.endpoint)
+}
+
+func (s *influxSink) writeSample(sample engine.Sample) {
+	fmt.Fprintf(&s.buffer, "chail_request,%s,code=%d,category=%s time_starttransfer=%s,time_total=%s,success=%t %d\n",
+		s.tagSet(), sample.ResponseCode, influxCategory(sample), formatFloat(sample.TimeStartTransfer.Seconds()), formatFloat(sample.TimeTotal.Seconds()), sample.IsSuccessful(), sample.Start.UnixNano())
+}
+
+func (s *influxSink) writeProbe(probe engine.Probe) {
+	fmt.Fprintf(&s.buffer, "chail_probe,%s clients=%di,requests=%di,avg_starttransfer=%s,avg_total=%s,p95_total=%s,error_rate=%s,rps=%s %d\n",
+		s.tagSet(), probe.Clients, probe.Requests, formatFloat(probe.AvgTimeStartTransferNano/1e9), formatFloat(probe.AvgTimeTotalNano/1e9), formatFloat(probe.Percentile(95)/1e9), formatFloat(probe.ErrRate), formatFloat(probe.RPS()), time.Now().UnixNano())
+}
+
+func (s *influxSink) flush() error {
+	if s.buffer.Len() == 0 {
+		return nil
+	}
+	resp, err := s.client.Post(s.url, "text/plain; charset=utf-8", &s.buffer)
+	s.buffer.Reset()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("influx responds with %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *influxSink) close() error {
+	return s.flush()
+}
+
+// influxCategory is the error category as tag value, which must not be empty
+func influxCategory(sample engine.Sample) string {
+	if category := sample.Category(); category != "" {
+		return category
+	}
+	return "none"
+}
+
+// graphiteSink writes tagged plaintext over TCP
+type graphiteSink struct {
+	conn   net.Conn
+	tags   sinkTags
+	buffer bytes.Buffer
+}
+
+var graphiteTagEscaper = strings.NewReplacer(";", "_", "~", "_", " ", "_", "!", "_", "^", "_", "=", "_")
+
+func (s *graphiteSink) write(metric string, value float64, at time.Time, extra string) {
+	fmt.Fprintf(&s.buffer, "chail.%s;scenario=%s;endpoint=%s%s %s %d\n",
+		metric, graphiteTagEscaper.Replace(s.tags.scenario), graphiteTagEscaper.Replace(s.tags.endpoint), extra, formatFloat(value), at.Unix())
+}
+
+func (s *graphiteSink) writeSample(sample engine.Sample) {
+	extra := ";code=" + strconv.Itoa(sample.ResponseCode)
+	s.write("request.time_total", sample.TimeTotal.Seconds(), sample.Start, extra)
+	s.write("request.time_starttransfer", sample.TimeStartTransfer.Seconds(), sample.Start, extra)
+}
+
+func (s *graphiteSink) writeProbe(probe engine.Probe) {
+	now := time.Now()
+	s.write("probe.clients", float64(probe.Clients), now, "")
+	s.write("probe.avg_total", probe.AvgTimeTotalNano/1e9, now, "")
+	s.write("probe.p95_total", probe.Percentile(95)/1e9, now, "")
+	s.write("probe.error_rate", probe.ErrRate, now, "")
+	s.write("probe.rps", probe.RPS(), now, "")
+}
+
+func (s *graphiteSink) flush() error {
+	_, err := s.buffer.WriteTo(s.conn)
+	return err
+}
+
+func (s *graphiteSink) close() error {
+	err := s.flush()
+	s.conn.Close()
+	return err
+}
+
+// statsdSink sends timers, counters and gauges with tags over UDP
+type statsdSink struct {
+	conn   net.Conn
+	tags   sinkTags
+	buffer bytes.Buffer
+	lines  []string
+}
+
+var statsdTagEscaper = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+func (s *statsdSink) tagSet() string {
+	return "scenario:" + statsdTagEscaper.Replace(s.tags.scenario) + ",endpoint:" + statsdTagEscaper.Replace(s.tags.endpoint)
+}
+
+func (s *statsdSink) writeSample(sample engine.Sample) {
+	s.lines = append(s.lines, fmt.Sprintf("chail.requests:1|c|#%s,code:%d", s.tagSet(), sample.ResponseCode))
+	if sample.TimeTotal > 0 {
+		s.lines = append(s.lines, fmt.Sprintf("chail.request.time_total:%s|ms|#%s", formatFloat(float64(sample.TimeTotal)/1e6), s.tagSet()))
+	}
+}
+
+func (s *statsdSink) writeProbe(probe engine.Probe) {
+	s.lines = append(s.lines,
+		fmt.Sprintf("chail.probe.clients:%d|g|#%s", probe.Clients, s.tagSet()),
+		fmt.Sprintf("chail.probe.avg_total:%s|g|#%s", formatFloat(probe.AvgTimeTotalNano/1e6), s.tagSet()),
+		fmt.Sprintf("chail.probe.error_rate:%s|g|#%s", formatFloat(probe.ErrRate), s.tagSet()),
+		fmt.Sprintf("chail.probe.rps:%s|g|#%s", formatFloat(probe.RPS()), s.tagSet()))
+}
+
+// flush packs the lines into datagrams of at most statsdMaxPayload bytes
+func (s *statsdSink) flush() error {
+	var err error
+	for _, line := range s.lines {
+		if s.buffer.Len() > 0 && s.buffer.Len()+1+len(line) > statsdMaxPayload {
+			if _, e := s.conn.Write(s.buffer.Bytes()); e != nil {
+				err = e
+			}
+			s.buffer.Reset()
+		}
+		if s.buffer.Len() > 0 {
+			s.buffer.WriteByte('\n')
+		}
+		s.buffer.WriteString(line)
+	}
+	if s.buffer.Len() > 0 {
+		if _, e := s.conn.Write(s.buffer.Bytes()); e != nil {
+			err = e
+		}
+		s.buffer.Reset()
+	}
+	s.lines = s.lines[:0]
+	return err
+}
+
+func (s *statsdSink) close() error {
+	err := s.flush()
+	s.conn.Close()
+	return err
+}
+
+// formatFloat without exponent and trailing zeros, NaN as 0
+func formatFloat(f float64) string {
+	if math.IsNaN(f) {
+		f = 0
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
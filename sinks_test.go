@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcjr/chail/engine"
+)
+
+var sinkTestTags = sinkTags{"smoke test", "localhost:8080/product/123"}
+
+func TestSinksSet(t *testing.T) {
+	var sinks Sinks
+	for _, arg := range []string{"influx=http://localhost:8086/write?db=chail", "graphite=localhost:2003", "statsd=localhost:8125"} {
+		if err := sinks.Set(arg); err != nil {
+			t.Errorf("Sinks.Set(%q) has error: %v", arg, err)
+		}
+	}
+	if sinks.String() != "influx=http://localhost:8086/write?db=chail,graphite=localhost:2003,statsd=localhost:8125" {
+		t.Errorf("Sinks.Set has invalid value: %q", sinks.String())
+	}
+	for _, arg := range []string{"kafka=localhost:9092", "statsd=", "localhost:8125"} {
+		if err := sinks.Set(arg); err == nil {
+			t.Errorf("Sinks.Set(%q) must return an error!", arg)
+		}
+	}
+}
+
+func TestNewSinkTags(t *testing.T) {
+	tags := newSinkTags("smoke", "http://localhost:8080/product/123?id=1")
+	if tags.scenario != "smoke" || tags.endpoint != "localhost:8080/product/123" {
+		t.Errorf("newSinkTags has invalid value: %+v", tags)
+	}
+}
+
+func TestInfluxSink(t *testing.T) {
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s, err := newSink(SinkSpec{"influx", server.URL + "/write?db=chail"}, sinkTestTags)
+	if err != nil {
+		t.Fatalf("newSink has error: %v", err)
+	}
+	writeSinkTestData(t, s)
+
+	body := <-bodies
+	assertSinkLines(t, body, []string{
+		`chail_request,scenario=smoke\ test,endpoint=localhost:8080/product/123,code=200,category=none time_starttransfer=0.01,time_total=0.02,success=true 1577836800000000000`,
+		`chail_probe,scenario=smoke\ test,endpoint=localhost:8080/product/123 clients=1i,requests=1i,avg_starttransfer=0.01,avg_total=0.02,p95_total=0.02,error_rate=0,rps=1 `,
+	})
+}
+
+func TestGraphiteSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		lines, _ := io.ReadAll(conn)
+		received <- string(lines)
+	}()
+
+	s, err := newSink(SinkSpec{"graphite", listener.Addr().String()}, sinkTestTags)
+	if err != nil {
+		t.Fatalf("newSink has error: %v", err)
+	}
+	writeSinkTestData(t, s)
+	s.close()
+
+	assertSinkLines(t, <-received, []string{
+		"chail.request.time_total;scenario=smoke_test;endpoint=localhost:8080/product/123;code=200 0.02 1577836800\n",
+		"chail.probe.clients;scenario=smoke_test;endpoint=localhost:8080/product/123 1 ",
+		"chail.probe.avg_total;scenario=smoke_test;endpoint=localhost:8080/product/123 0.02 ",
+	})
+}
+
+func TestStatsdSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Setup test failed! %v", err)
+	}
+	defer conn.Close()
+
+	s, err := newSink(SinkSpec{"statsd", conn.LocalAddr().String()}, sinkTestTags)
+	if err != nil {
+		t.Fatalf("newSink has error: %v", err)
+	}
+	writeSinkTestData(t, s)
+	s.close()
+
+	buf := make([]byte, statsdMaxPayload)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Reading datagram failed: %v", err)
+	}
+	assertSinkLines(t, string(buf[:n]), []string{
+		"chail.requests:1|c|#scenario:smoke test,endpoint:localhost:8080/product/123,code:200",
+		"chail.request.time_total:20|ms|#scenario:smoke test,endpoint:localhost:8080/product/123",
+		"chail.probe.clients:1|g|#scenario:smoke test,endpoint:localhost:8080/product/123",
+		"chail.probe.rps:1|g|#scenario:smoke test,endpoint:localhost:8080/product/123",
+	})
+}
+
+func TestSinkWriter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	request := engine.Request{URL: server.URL, Header: engine.Header{}}
+	request.Build()
+
+	recorder := &recordingSink{}
+	runner := engine.NewRunner(engine.Options{Request: request, NumClients: 2, NumRepeats: 3, Observers: []engine.Observer{&sinkWriter{sink: recorder}}})
+	runner.Exec(context.Background(), 2)
+	if recorder.samples != 6 || recorder.probes != 1 || recorder.flushes != 1 {
+		t.Errorf("Exec writes %d samples, %d probes and %d flushes to sinks, expected 6, 1 and 1", recorder.samples, recorder.probes, recorder.flushes)
+	}
+}
+
+func TestSinkWriterBatches(t *testing.T) {
+	recorder := &recordingSink{}
+	w := &sinkWriter{sink: recorder}
+	w.StepStarted(1)
+	for range sinkBatchSamples {
+		w.SampleCollected(engine.Sample{ResponseCode: 200})
+	}
+	if recorder.flushes != 1 {
+		t.Errorf("sinkWriter must flush a full batch before the end of the step, but flushed %d times", recorder.flushes)
+	}
+	w.flushed = time.Now().Add(-sinkFlushInterval)
+	w.SampleCollected(engine.Sample{ResponseCode: 200})
+	if recorder.flushes != 2 {
+		t.Errorf("sinkWriter must flush after the flush interval, but flushed %d times", recorder.flushes)
+	}
+	w.StepDone(engine.Probe{Clients: 1})
+	if recorder.samples != sinkBatchSamples+1 || recorder.flushes != 3 {
+		t.Errorf("sinkWriter writes %d samples and %d flushes, expected %d and 3", recorder.samples, recorder.flushes, sinkBatchSamples+1)
+	}
+}
+
+type recordingSink struct {
+	samples, probes, flushes int
+}
+
+func (s *recordingSink) writeSample(sample engine.Sample) { s.samples++ }
+func (s *recordingSink) writeProbe(probe engine.Probe)    { s.probes++ }
+func (s *recordingSink) flush() error                     { s.flushes++; return nil }
+func (s *recordingSink) close() error                     { return nil }
+
+func writeSinkTestData(t *testing.T, s sink) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.writeSample(engine.Sample{ResponseCode: 200, TimeStartTransfer: 10 * time.Millisecond, TimeTotal: 20 * time.Millisecond, Start: start})
+	s.writeProbe(engine.Probe{Clients: 1, Requests: 1, AvgTimeStartTransferNano: 10e6, AvgTimeTotalNano: 20e6, Duration: time.Second, TimeTotalNanos: []int64{20e6}})
+	if err := s.flush(); err != nil {
+		t.Errorf("Flushing sink failed: %v", err)
+	}
+}
+
+func assertSinkLines(t *testing.T, received string, expectedLines []string) {
+	for _, expected := range expectedLines {
+		if !strings.Contains(received, expected) {
+			t.Errorf("Sink misses %q in:\n%s", expected, received)
+		}
+	}
+}